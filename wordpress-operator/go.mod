@@ -1,17 +1,14 @@
 module github.com/renan-campos/wordpress-operator
 
-go 1.13
+go 1.16
 
 require (
-	github.com/operator-framework/operator-sdk v0.18.2
+	github.com/go-logr/logr v1.2.0
+	github.com/operator-framework/operator-lib v0.4.1
 	github.com/spf13/pflag v1.0.5
-	k8s.io/api v0.18.2
-	k8s.io/apimachinery v0.18.2
-	k8s.io/client-go v12.0.0+incompatible
-	sigs.k8s.io/controller-runtime v0.6.0
-)
-
-replace (
-	github.com/Azure/go-autorest => github.com/Azure/go-autorest v13.3.2+incompatible // Required by OLM
-	k8s.io/client-go => k8s.io/client-go v0.18.2 // Required by prometheus-operator
+	k8s.io/api v0.23.17
+	k8s.io/apimachinery v0.23.17
+	k8s.io/client-go v0.23.17
+	sigs.k8s.io/controller-runtime v0.11.2
+	sigs.k8s.io/structured-merge-diff/v4 v4.2.3
 )
@@ -1,18 +1,429 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // WordpressSpec defines the desired state of Wordpress
 type WordpressSpec struct {
-	Password string `json:"sqlRootPassword"`
+	// Password is the MySQL root password in plain text.
+	// Deprecated: use Database.PasswordSecretRef instead.
+	Password string `json:"sqlRootPassword,omitempty"`
+
+	// Wordpress configures the WordPress (frontend) tier.
+	Wordpress WordpressTierSpec `json:"wordpress,omitempty"`
+	// Database configures the MySQL tier.
+	Database DatabaseSpec `json:"database,omitempty"`
+	// Storage configures the persistent volume of each tier.
+	Storage StorageSpec `json:"storage,omitempty"`
+	// Ingress exposes the site at its hostnames through an ingress
+	// controller, which many sites can share.
+	Ingress *IngressSpec `json:"ingress,omitempty"`
+	// NetworkPolicy decides who may reach the pods of the site. Only the
+	// site's own pods can reach its database.
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+	// Size is the profile the resource requests and limits of each tier
+	// default to. Defaults to the profile the operator is configured with.
+	// +kubebuilder:validation:Enum=small;medium;large
+	Size SizeProfile `json:"size,omitempty"`
+	// SecurityProfile decides how the pods of the site are secured. Restricted
+	// meets the restricted Pod Security Standard: the pods run as the
+	// unprivileged user of the official images, without capabilities, with
+	// the RuntimeDefault seccomp profile and a read-only root filesystem.
+	// WordPress then listens on port 8080. Defaults to Default.
+	// +kubebuilder:validation:Enum=Default;Restricted
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+	// DeletionPolicy decides what happens to the data of the site when the
+	// Wordpress is deleted. Defaults to Delete.
+	// +kubebuilder:validation:Enum=Retain;Delete;BackupThenDelete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// NetworkPolicySpec decides who may reach the WordPress pods of a site
+type NetworkPolicySpec struct {
+	// WordpressFrom are the only peers allowed to reach WordPress, typically
+	// the namespace or the pods of the ingress controller. When empty,
+	// WordPress accepts traffic from the pods in its own namespace, from the
+	// namespace of the ingress controller the operator is configured with
+	// (ingress-nginx unless INGRESS_CONTROLLER_NAMESPACE is set) and, for a
+	// LoadBalancer or NodePort Service, from wordpress.loadBalancerSourceRanges,
+	// or from any address when those are not set.
+	WordpressFrom []networkingv1.NetworkPolicyPeer `json:"wordpressFrom,omitempty"`
+}
+
+// SizeProfile names a set of resource requests and limits for each tier
+type SizeProfile string
+
+const (
+	SizeSmall  SizeProfile = "small"
+	SizeMedium SizeProfile = "medium"
+	SizeLarge  SizeProfile = "large"
+)
+
+// SecurityProfile decides how the pods of a site are secured
+type SecurityProfile string
+
+const (
+	// SecurityProfileDefault runs the images the way they are built, which
+	// for the official images means as root.
+	SecurityProfileDefault SecurityProfile = "Default"
+	// SecurityProfileRestricted meets the restricted Pod Security Standard.
+	SecurityProfileRestricted SecurityProfile = "Restricted"
+)
+
+// DeletionPolicy decides what happens to the data of a site when its Wordpress is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain keeps the PersistentVolumeClaims and the Secret of
+	// the site. A new Wordpress with the same name adopts them again.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDelete deletes every object of the site, including its data.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyBackupThenDelete writes a final backup of the database and
+	// the WordPress files to a PersistentVolumeClaim that is kept, then deletes
+	// every other object of the site.
+	DeletionPolicyBackupThenDelete DeletionPolicy = "BackupThenDelete"
+)
+
+// WordpressTierSpec defines the desired state of the WordPress tier
+type WordpressTierSpec struct {
+	// Image is the WordPress container image. Changing it rolls out the
+	// new image to the existing Deployment. Defaults to wordpress:6.8-apache;
+	// a site that leaves it empty keeps the image it runs, so that a new
+	// default never upgrades a site. The WordPress files on the volume are
+	// not replaced by a new image, so update WordPress from its dashboard
+	// before moving to an image with a newer PHP release.
+	Image string `json:"image,omitempty"`
+	// Replicas is the number of WordPress pods. More than one pod needs a
+	// ReadWriteMany volume to share uploads; see Storage.Wordpress. Ignored
+	// when Autoscaling is set. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Autoscaling lets a HorizontalPodAutoscaler decide the number of
+	// WordPress pods.
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+	// ServiceType is the type of the WordPress Service. Sites behind an
+	// Ingress do not need a load balancer of their own. Defaults to
+	// LoadBalancer.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+	// LoadBalancerSourceRanges are the CIDRs of the clients allowed to reach
+	// a LoadBalancer or NodePort Service. They limit the load balancer, and
+	// the NetworkPolicy of WordPress admits them when
+	// networkPolicy.wordpressFrom is empty.
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+	// Resources are the compute resources of the WordPress container. When
+	// set, they replace those of the size profile.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// HealthCheckPath is the page the readiness probe of the WordPress pods
+	// requests. Defaults to /wp-login.php.
+	HealthCheckPath string `json:"healthCheckPath,omitempty"`
+	// Probes override the timings of the probes of the WordPress container.
+	Probes *ProbesSpec `json:"probes,omitempty"`
+	// SchedulingSpec decides where the WordPress pods run. Without an
+	// affinity, the pods prefer different nodes whenever there can be more
+	// than one.
+	SchedulingSpec `json:",inline"`
+}
+
+// SchedulingSpec decides where the pods of a tier run
+type SchedulingSpec struct {
+	// NodeSelector restricts the pods to nodes with these labels.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations let the pods run on nodes with matching taints.
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity constrains the pods to nodes, or relative to other pods.
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// TopologySpreadConstraints spread the pods across zones or nodes.
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// PriorityClassName is the PriorityClass of the pods.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// ProbesSpec overrides the timings of the probes of a container
+type ProbesSpec struct {
+	// Startup holds off the other probes until the server has started.
+	Startup *ProbeSpec `json:"startup,omitempty"`
+	// Liveness restarts the container when the server hangs.
+	Liveness *ProbeSpec `json:"liveness,omitempty"`
+	// Readiness takes the pod out of its Services while it cannot serve.
+	Readiness *ProbeSpec `json:"readiness,omitempty"`
+}
+
+// ProbeSpec overrides the timings of a probe. Timings left unset keep the
+// operator's defaults.
+type ProbeSpec struct {
+	// +kubebuilder:validation:Minimum=0
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold *int32 `json:"failureThreshold,omitempty"`
+}
+
+// AutoscalingSpec defines the HorizontalPodAutoscaler of the WordPress tier
+type AutoscalingSpec struct {
+	// MinReplicas is the lowest number of pods. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas is the highest number of pods.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetCPUUtilizationPercentage is the average CPU utilization of the
+	// pods, relative to their CPU request, to scale towards. Defaults to 80
+	// when Metric is not set.
+	// +kubebuilder:validation:Minimum=1
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	// Metric is a custom metric to scale on, alongside the CPU target when
+	// both are set.
+	Metric *PodsMetricSpec `json:"metric,omitempty"`
+}
+
+// PodsMetricSpec defines a custom metric describing each WordPress pod
+type PodsMetricSpec struct {
+	// Name of the metric, as served by the custom metrics API.
+	Name string `json:"name"`
+	// TargetAverageValue is the value of the metric, averaged over the pods,
+	// to scale towards.
+	TargetAverageValue resource.Quantity `json:"targetAverageValue"`
+}
+
+// DatabaseSpec defines the desired state of the MySQL tier
+type DatabaseSpec struct {
+	// Engine is the database server to run. Defaults to mysql. It cannot be
+	// changed after the site is created.
+	// +kubebuilder:validation:Enum=mysql;mariadb
+	Engine DatabaseEngine `json:"engine,omitempty"`
+	// Image is the database container image. Changing it rolls out the
+	// new image to the existing StatefulSet. Defaults to mysql:8.4 for the
+	// mysql engine and mariadb:10.11 for the mariadb engine; a site that
+	// leaves it empty keeps the image it runs. MySQL only upgrades data from
+	// the release series before its own, so a site on mysql:5.6 moves to
+	// mysql:5.7, mysql:8.0 and then mysql:8.4, waiting for status.databaseImage
+	// to report each one.
+	// With an external database it is only used to verify the connection,
+	// and defaults to mysql:8.4.
+	Image string `json:"image,omitempty"`
+	// PasswordSecretRef selects the key of a Secret in the Wordpress namespace
+	// holding the MySQL root password. It takes precedence over sqlRootPassword.
+	// When neither is set, a random root password is generated.
+	PasswordSecretRef *corev1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+	// Replicas is the number of asynchronous read replicas to run next to the
+	// primary. Each is seeded from the primary. Sites whose database volume
+	// predates the StatefulSet cannot run replicas. Defaults to 0.
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas,omitempty"`
+	// Resources are the compute resources of the database container. When
+	// set, they replace those of the size profile.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Probes override the timings of the probes of the database container.
+	Probes *ProbesSpec `json:"probes,omitempty"`
+	// SchedulingSpec decides where the database pods run.
+	SchedulingSpec `json:",inline"`
+	// External points WordPress at a MySQL server the operator does not
+	// manage. When it is set, no MySQL StatefulSet, Service or volume is created.
+	External *ExternalDatabaseSpec `json:"external,omitempty"`
+}
+
+// DatabaseEngine is a database server the operator can run
+type DatabaseEngine string
+
+const (
+	// DatabaseEngineMySQL runs the official MySQL image.
+	DatabaseEngineMySQL DatabaseEngine = "mysql"
+	// DatabaseEngineMariaDB runs the official MariaDB image.
+	DatabaseEngineMariaDB DatabaseEngine = "mariadb"
+)
+
+// ExternalDatabaseSpec defines how to connect to a MySQL server outside the cluster
+type ExternalDatabaseSpec struct {
+	// Host is the hostname or IP address of the MySQL server.
+	Host string `json:"host"`
+	// Port of the MySQL server. Defaults to 3306.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
+	// DatabaseName is the database WordPress uses. Defaults to wordpress.
+	DatabaseName string `json:"databaseName,omitempty"`
+	// User is the MySQL user WordPress connects as.
+	User string `json:"user"`
+	// CredentialsSecretRef selects the key of a Secret in the Wordpress
+	// namespace holding the password of User.
+	CredentialsSecretRef corev1.SecretKeySelector `json:"credentialsSecretRef"`
+}
+
+// IngressSpec defines the Ingress of a site
+type IngressSpec struct {
+	// Hosts are the hostnames the site is served at.
+	// +kubebuilder:validation:MinItems=1
+	Hosts []string `json:"hosts"`
+	// IngressClassName selects the ingress controller that serves the site.
+	// When unset, the cluster default is used.
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+	// Annotations are added to the Ingress, to configure the ingress controller.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// TLS serves the site over HTTPS. Without it, the site is served over
+	// plain HTTP.
+	TLS *IngressTLSSpec `json:"tls,omitempty"`
+}
+
+// IngressTLSSpec defines the certificate a site is served with
+type IngressTLSSpec struct {
+	// SecretName names the Secret in the Wordpress namespace holding the
+	// certificate for the hosts. With IssuerRef or OperatorCA it is where
+	// the issued certificate is stored, and defaults to the name of the
+	// Wordpress followed by -tls; otherwise it is required.
+	SecretName string `json:"secretName,omitempty"`
+	// IssuerRef has cert-manager issue and renew a certificate covering
+	// every host of the site.
+	IssuerRef *IssuerReference `json:"issuerRef,omitempty"`
+	// OperatorCA has the operator's own certificate authority issue and
+	// renew a certificate covering every host of the site, for clusters
+	// without cert-manager. Clients trust the site through the CA bundle in
+	// the wordpress-operator-ca ConfigMap in the namespace of the operator.
+	// Ignored when IssuerRef is set.
+	OperatorCA bool `json:"operatorCA,omitempty"`
+}
+
+// IssuerReference selects a cert-manager issuer
+type IssuerReference struct {
+	// Name of the issuer.
+	Name string `json:"name"`
+	// Kind of the issuer, Issuer or ClusterIssuer. Defaults to Issuer.
+	Kind string `json:"kind,omitempty"`
+	// Group of the issuer. Defaults to cert-manager.io.
+	Group string `json:"group,omitempty"`
+}
+
+// StorageSpec defines the persistent storage of each tier
+type StorageSpec struct {
+	// Wordpress is the volume mounted at /var/www/html. It defaults to
+	// ReadWriteMany when the site asks for more than one WordPress pod, and
+	// must be ReadWriteMany for it to run more than one.
+	Wordpress VolumeSpec `json:"wordpress,omitempty"`
+	// Database is the volume holding the MySQL data directory.
+	Database VolumeSpec `json:"database,omitempty"`
+}
+
+// VolumeSpec defines the PersistentVolumeClaim of a tier
+type VolumeSpec struct {
+	// Size is the requested capacity. Defaults to 20Gi. Growing it expands the
+	// existing claim when its StorageClass allows expansion; shrinking is rejected.
+	Size *resource.Quantity `json:"size,omitempty"`
+	// StorageClassName is the StorageClass of the claim. When unset, the
+	// cluster default StorageClass is used. Cannot be changed after creation.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes of the claim. Defaults to ReadWriteOnce. Cannot be changed
+	// after creation.
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// WordpressPhase summarizes the state of a Wordpress site
+type WordpressPhase string
+
+const (
+	// PhaseProvisioning means the site has not been ready yet.
+	PhaseProvisioning WordpressPhase = "Provisioning"
+	// PhaseReady means every component of the site is ready.
+	PhaseReady WordpressPhase = "Ready"
+	// PhaseDegraded means the site was ready before, but a component no longer is.
+	PhaseDegraded WordpressPhase = "Degraded"
+	// PhaseFailed means the site cannot progress until its spec or its
+	// environment changes: a volume resize was rejected, the spec conflicts
+	// with an immutable field, an image is not supported by the security
+	// profile, or a Job failed. The Ready condition tells which.
+	PhaseFailed WordpressPhase = "Failed"
+)
+
+// Condition types reported in WordpressStatus.Conditions.
+const (
+	// ConditionReady is true when every component of the site is ready.
+	ConditionReady = "Ready"
+	// ConditionDatabaseReady is true when MySQL is available.
+	ConditionDatabaseReady = "DatabaseReady"
+	// ConditionWordPressReady is true when WordPress is available.
+	ConditionWordPressReady = "WordPressReady"
+	// ConditionStorageBound is true when every PersistentVolumeClaim is bound.
+	ConditionStorageBound = "StorageBound"
+	// ConditionServiceReady is true when the Services of the site exist.
+	ConditionServiceReady = "ServiceReady"
+	// ConditionStorageResizeFailed is true when a requested volume size could
+	// not be applied, e.g. a shrink or an expansion the StorageClass forbids.
+	ConditionStorageResizeFailed = "StorageResizeFailed"
+	// ConditionReplicationHealthy is true when every read replica is
+	// replicating from the primary. It is only reported when there are replicas.
+	ConditionReplicationHealthy = "ReplicationHealthy"
+	// ConditionScalingBlocked is true when the site asks for more than one
+	// WordPress pod but its WordPress volume cannot be shared between pods.
+	ConditionScalingBlocked = "ScalingBlocked"
+	// ConditionCertificateReady is true when the certificate of the site has
+	// been issued. It is only reported when spec.ingress.tls.issuerRef or
+	// spec.ingress.tls.operatorCA is set.
+	ConditionCertificateReady = "CertificateReady"
+	// ConditionSecurityProfileSupported is true when the images of the site
+	// are known to run under its security profile. It is only reported for
+	// the Restricted profile.
+	ConditionSecurityProfileSupported = "SecurityProfileSupported"
+	// ConditionImmutableFieldConflict is true when the spec asks for a change
+	// to a field that cannot be changed on an existing object, such as the
+	// StorageClass of a PersistentVolumeClaim.
+	ConditionImmutableFieldConflict = "ImmutableFieldConflict"
+	// ConditionProgressing is true while the MySQL StatefulSet or the
+	// WordPress Deployment rolls out, and while the last reconcile hit an
+	// error that is retried, such as an unavailable API server.
+	ConditionProgressing = "Progressing"
+)
+
+// ResourceReference identifies an object the operator manages for a Wordpress
+type ResourceReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// ReplicaStatus reports the replication health of a read replica
+type ReplicaStatus struct {
+	// Name is the name of the pod running the replica.
+	Name string `json:"name"`
+	// IOThreadRunning tells whether the replica is receiving the binary log of the primary.
+	IOThreadRunning bool `json:"ioThreadRunning"`
+	// SQLThreadRunning tells whether the replica is applying what it received.
+	SQLThreadRunning bool `json:"sqlThreadRunning"`
+	// SecondsBehindPrimary is the replication lag. It is unset while unknown.
+	SecondsBehindPrimary *int64 `json:"secondsBehindPrimary,omitempty"`
+	// LastError is the last replication error of the replica, if any.
+	LastError string `json:"lastError,omitempty"`
 }
 
 // WordpressStatus defines the observed state of Wordpress
 type WordpressStatus struct {
-	// Requirements do not require the state of the resource to be tracked.
-	// TODO: Track Pods, PVC, and Secret.
+	// Phase summarizes the state of the site.
+	// +kubebuilder:validation:Enum=Provisioning;Ready;Degraded;Failed
+	Phase WordpressPhase `json:"phase,omitempty"`
+	// ObservedGeneration is the generation of the spec this status describes.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// URL is where the site is served.
+	URL string `json:"url,omitempty"`
+	// Endpoints are the external addresses of the site.
+	Endpoints []string `json:"endpoints,omitempty"`
+	// WordpressImage is the WordPress image that is fully rolled out. A site
+	// that does not name an image keeps running it.
+	WordpressImage string `json:"wordpressImage,omitempty"`
+	// DatabaseImage is the MySQL image that is fully rolled out. A site that
+	// does not name an image keeps running it.
+	DatabaseImage string `json:"databaseImage,omitempty"`
+	// Conditions describe the current state of the site.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Resources references the objects created for the site.
+	Resources []ResourceReference `json:"resources,omitempty"`
+	// Replicas reports the replication health of each read replica.
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -20,6 +431,10 @@ type WordpressStatus struct {
 // Wordpress is the Schema for the wordpresses API
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:path=wordpresses,scope=Namespaced
+// +kubebuilder:printcolumn:name="URL",type="string",JSONPath=".status.url"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.wordpressImage"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type Wordpress struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,129 @@
+package wordpress
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// defaultTargetCPUUtilization is the CPU target of an autoscaler that is
+// given no metric to scale on.
+const defaultTargetCPUUtilization = 80
+
+// ensureAutoscaler creates the HorizontalPodAutoscaler of the WordPress tier
+// of m, or corrects drift on it. The autoscaler is deleted when autoscaling
+// is turned off, or when scaling is blocked because the WordPress volume
+// cannot be shared.
+func (r *ReconcileWordpress) ensureAutoscaler(m *examplev1.Wordpress, blocked bool, reqLogger logr.Logger) error {
+	if m.Spec.Wordpress.Autoscaling == nil || blocked {
+		return r.deleteIfExists(m, fmt.Sprintf("%s-wordpress", m.Name), &autoscalingv2.HorizontalPodAutoscaler{}, reqLogger)
+	}
+
+	hpa := r.wordpressAutoscalerForWordpress(m)
+	found := &autoscalingv2.HorizontalPodAutoscaler{}
+	_, err := r.ensure(hpa, found, func() (bool, error) {
+		return r.applyChanges(found, hpa)
+	}, reqLogger)
+	return err
+}
+
+func (r *ReconcileWordpress) wordpressAutoscalerForWordpress(m *examplev1.Wordpress) *autoscalingv2.HorizontalPodAutoscaler {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "frontend"
+
+	as := m.Spec.Wordpress.Autoscaling
+	minReplicas := int32(1)
+	if as.MinReplicas != nil {
+		minReplicas = *as.MinReplicas
+	}
+
+	var metrics []autoscalingv2.MetricSpec
+	if as.TargetCPUUtilizationPercentage != nil || as.Metric == nil {
+		utilization := int32(defaultTargetCPUUtilization)
+		if as.TargetCPUUtilizationPercentage != nil {
+			utilization = *as.TargetCPUUtilizationPercentage
+		}
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: corev1.ResourceCPU,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: &utilization,
+				},
+			},
+		})
+	}
+	if as.Metric != nil {
+		value := as.Metric.TargetAverageValue.DeepCopy()
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{Name: as.Metric.Name},
+				Target: autoscalingv2.MetricTarget{
+					Type:         autoscalingv2.AverageValueMetricType,
+					AverageValue: &value,
+				},
+			},
+		})
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-wordpress", m.Name),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       fmt.Sprintf("%s-wordpress", m.Name),
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: as.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+
+	controllerutil.SetControllerReference(m, hpa, r.scheme)
+
+	return hpa
+}
+
+// wordpressReplicas returns the number of WordPress pods m asks for. With
+// autoscaling, the autoscaler decides, so it returns nil.
+func wordpressReplicas(m *examplev1.Wordpress) *int32 {
+	if m.Spec.Wordpress.Autoscaling != nil {
+		return nil
+	}
+	replicas := int32(1)
+	if m.Spec.Wordpress.Replicas != nil {
+		replicas = *m.Spec.Wordpress.Replicas
+	}
+	return &replicas
+}
+
+// wordpressMaxReplicas returns the highest number of WordPress pods m may run.
+func wordpressMaxReplicas(m *examplev1.Wordpress) int32 {
+	if replicas := wordpressReplicas(m); replicas != nil {
+		return *replicas
+	}
+	return m.Spec.Wordpress.Autoscaling.MaxReplicas
+}
+
+// sharedStorage reports whether every pod on any node can mount claim pvc.
+func sharedStorage(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, mode := range pvc.Spec.AccessModes {
+		if mode == corev1.ReadWriteMany {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package wordpress
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestReconcileScalesOnSharedStorage(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Wordpress: examplev1.WordpressTierSpec{
+				Autoscaling: &examplev1.AutoscalingSpec{MaxReplicas: 5},
+			},
+		},
+	}
+	r := newTestReconciler(t, wp)
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, pvc); err != nil {
+		t.Fatal(err)
+	}
+	if !sharedStorage(pvc) {
+		t.Errorf("access modes = %v, want ReadWriteMany", pvc.Spec.AccessModes)
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, hpa); err != nil {
+		t.Fatalf("autoscaler was not created: %v", err)
+	}
+	if hpa.Spec.MaxReplicas != 5 || len(hpa.Spec.Metrics) != 1 || hpa.Spec.Metrics[0].Resource == nil {
+		t.Errorf("autoscaler spec = %+v, want up to 5 pods on CPU", hpa.Spec)
+	}
+
+	// The autoscaler owns the number of pods.
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Spec.Replicas != nil {
+		t.Errorf("replicas = %d, want them left to the autoscaler", *dep.Spec.Replicas)
+	}
+}
+
+func TestEnsureWordpressRefusesToScaleOnUnsharedStorage(t *testing.T) {
+	replicas := int32(3)
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Wordpress: examplev1.WordpressTierSpec{Replicas: &replicas},
+		},
+	}
+	r := newTestReconciler(t, wp)
+	// The claim of a site that used to run a single pod.
+	pvc := r.wordpressPVCForWordpress(&examplev1.Wordpress{ObjectMeta: wp.ObjectMeta})
+	if err := r.client.Create(context.TODO(), pvc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.ensureWordpress(wp, nil, true, log.WithValues("Request.Name", "mysite")); err != nil {
+		t.Fatal(err)
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Spec.Replicas == nil || *dep.Spec.Replicas != 1 {
+		t.Errorf("replicas = %v, want a single pod on a ReadWriteOnce claim", dep.Spec.Replicas)
+	}
+	if !meta.IsStatusConditionTrue(wp.Status.Conditions, examplev1.ConditionScalingBlocked) {
+		t.Errorf("condition %s is not true", examplev1.ConditionScalingBlocked)
+	}
+}
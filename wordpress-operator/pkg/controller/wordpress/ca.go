@@ -0,0 +1,316 @@
+package wordpress
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+	"github.com/renan-campos/wordpress-operator/pkg/k8sutil"
+)
+
+const (
+	// operatorCAName names the Secret holding the root certificate and key of
+	// the operator's CA, and the ConfigMap offering the certificate to clients.
+	operatorCAName = "wordpress-operator-ca"
+	// caBundleKey holds the certificate of the CA in the ConfigMap, and in
+	// the Secret of each certificate it issues.
+	caBundleKey = "ca.crt"
+
+	// How long certificates are valid. Site certificates are renewed once
+	// less than certificateRenewBefore of their validity is left.
+	caValidity             = 10 * 365 * 24 * time.Hour
+	certificateValidity    = 90 * 24 * time.Hour
+	certificateRenewBefore = 30 * 24 * time.Hour
+)
+
+// certificateAuthority is the operator's own CA.
+type certificateAuthority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+// operatorCANamespace returns the namespace the root of the operator's CA is
+// kept in: the namespace the operator runs in or, when it runs outside the
+// cluster, the first namespace it watches.
+func operatorCANamespace() string {
+	if ns, err := k8sutil.GetOperatorNamespace(); err == nil {
+		return ns
+	}
+	if ns, err := k8sutil.GetWatchNamespace(); err == nil && ns != "" {
+		return strings.Split(ns, ",")[0]
+	}
+	return "default"
+}
+
+// ensureOperatorCertificate issues a certificate covering every host of m
+// from the operator's CA into the TLS Secret of its Ingress, when the spec
+// asks for one. The certificate is issued again when the hosts change, when
+// it was issued by another CA, and ahead of its expiry. It returns how long
+// until the certificate needs renewing, or 0 when there is none.
+func (r *ReconcileWordpress) ensureOperatorCertificate(m *examplev1.Wordpress, reqLogger logr.Logger) (time.Duration, error) {
+	if !operatorCAEnabled(m) {
+		return 0, nil
+	}
+	ca, err := r.operatorCA(reqLogger)
+	if err != nil {
+		return 0, err
+	}
+	hosts := m.Spec.Ingress.Hosts
+	now := time.Now()
+
+	found := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: tlsSecretName(m), Namespace: m.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	exists := err == nil
+	if exists {
+		if renewAt, ok := certificateRenewal(found, ca, hosts); ok && now.Before(renewAt) {
+			return renewAt.Sub(now), nil
+		}
+	}
+
+	certPEM, keyPEM, err := ca.issue(hosts, now)
+	if err != nil {
+		return 0, err
+	}
+	sec := r.tlsSecretForWordpress(m, certPEM, keyPEM, ca.certPEM)
+	if !exists {
+		reqLogger.Info("Issuing a certificate", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name, "Hosts", hosts)
+		err = r.apply(sec)
+	} else {
+		reqLogger.Info("Renewing a certificate", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name, "Hosts", hosts)
+		_, err = r.applyChanges(found, sec)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return certificateValidity - certificateRenewBefore, nil
+}
+
+// tlsSecretForWordpress returns the Secret the Ingress of m is served with,
+// holding a certificate of the operator's CA followed by the CA certificate.
+func (r *ReconcileWordpress) tlsSecretForWordpress(m *examplev1.Wordpress, certPEM, keyPEM, caPEM []byte) *corev1.Secret {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "frontend"
+
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tlsSecretName(m),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       append(append([]byte{}, certPEM...), caPEM...),
+			corev1.TLSPrivateKeyKey: keyPEM,
+			caBundleKey:             caPEM,
+		},
+	}
+
+	controllerutil.SetControllerReference(m, sec, r.scheme)
+
+	return sec
+}
+
+// operatorCA returns the operator's CA, creating its root the first time it
+// is needed, and offers the CA certificate through a ConfigMap next to it.
+func (r *ReconcileWordpress) operatorCA(reqLogger logr.Logger) (*certificateAuthority, error) {
+	key := types.NamespacedName{Name: operatorCAName, Namespace: r.caNamespace}
+	sec := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), key, sec)
+	if errors.IsNotFound(err) {
+		data, err := newCertificateAuthority(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		sec = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}
+		reqLogger.Info("Creating the operator CA", "Secret.Namespace", key.Namespace, "Secret.Name", key.Name)
+		err = r.client.Create(context.TODO(), sec)
+		if errors.IsAlreadyExists(err) {
+			err = r.client.Get(context.TODO(), key, sec)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	ca, err := parseCertificateAuthority(sec.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Secret %s/%s: %w", key.Namespace, key.Name, err)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Data:       map[string]string{caBundleKey: string(ca.certPEM)},
+	}
+	found := &corev1.ConfigMap{}
+	_, err = r.ensure(cm, found, func() (bool, error) {
+		if reflect.DeepEqual(found.Data, cm.Data) {
+			return false, nil
+		}
+		return r.applyChanges(found, cm)
+	}, reqLogger)
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// newCertificateAuthority returns the Secret data of a new root certificate
+// and key.
+func newCertificateAuthority(now time.Time) (map[string][]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "wordpress-operator CA"},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeECKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: keyPEM,
+	}, nil
+}
+
+func parseCertificateAuthority(data map[string][]byte) (*certificateAuthority, error) {
+	cert, err := parseCertificate(data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data[corev1.TLSPrivateKeyKey])
+	if block == nil {
+		return nil, fmt.Errorf("no private key in %s", corev1.TLSPrivateKeyKey)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &certificateAuthority{cert: cert, certPEM: data[corev1.TLSCertKey], key: key}, nil
+}
+
+// issue returns a certificate for hosts, and its key.
+func (ca *certificateAuthority) issue(hosts []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := encodeECKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// certificateRenewal returns when the certificate in sec is due for renewal.
+// It reports false when the certificate has to be issued again right away:
+// when it cannot be read, was not issued by ca or does not cover exactly hosts.
+func certificateRenewal(sec *corev1.Secret, ca *certificateAuthority, hosts []string) (time.Time, bool) {
+	cert, err := parseCertificate(sec.Data[corev1.TLSCertKey])
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !bytes.Equal(sec.Data[caBundleKey], ca.certPEM) || cert.CheckSignatureFrom(ca.cert) != nil {
+		return time.Time{}, false
+	}
+	if !reflect.DeepEqual(sortedCopy(cert.DNSNames), sortedCopy(hosts)) {
+		return time.Time{}, false
+	}
+	return cert.NotAfter.Add(-certificateRenewBefore), true
+}
+
+// parseCertificate returns the first certificate in data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate in %s", corev1.TLSCertKey)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func encodeECKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func sortedCopy(s []string) []string {
+	c := append([]string{}, s...)
+	sort.Strings(c)
+	return c
+}
+
+// operatorCAEnabled reports whether the certificate of m is issued by the
+// operator's CA.
+func operatorCAEnabled(m *examplev1.Wordpress) bool {
+	return m.Spec.Ingress != nil && m.Spec.Ingress.TLS != nil && m.Spec.Ingress.TLS.OperatorCA &&
+		m.Spec.Ingress.TLS.IssuerRef == nil && len(m.Spec.Ingress.Hosts) > 0
+}
@@ -0,0 +1,103 @@
+package wordpress
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestReconcileIssuesCertificateFromOperatorCA(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Ingress: &examplev1.IngressSpec{
+				Hosts: []string{"blog.example.internal"},
+				TLS:   &examplev1.IngressTLSSpec{OperatorCA: true},
+			},
+		},
+	}
+	r := newTestReconciler(t, wp)
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	bundle := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: operatorCAName, Namespace: "operators"}, bundle); err != nil {
+		t.Fatalf("CA bundle was not offered: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(bundle.Data[caBundleKey])) {
+		t.Fatal("CA bundle holds no certificate")
+	}
+
+	sec := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-tls", Namespace: "default"}, sec); err != nil {
+		t.Fatalf("certificate was not issued: %v", err)
+	}
+	cert, err := parseCertificate(sec.Data[corev1.TLSCertKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "blog.example.internal", Roots: roots}); err != nil {
+		t.Errorf("certificate is not trusted through the CA bundle: %v", err)
+	}
+}
+
+func TestCertificateRenewal(t *testing.T) {
+	data, err := newCertificateAuthority(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := parseCertificateAuthority(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestReconciler(t)
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec:       examplev1.WordpressSpec{Ingress: &examplev1.IngressSpec{TLS: &examplev1.IngressTLSSpec{OperatorCA: true}}},
+	}
+	secretFor := func(hosts []string, issued time.Time) *corev1.Secret {
+		certPEM, keyPEM, err := ca.issue(hosts, issued)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.tlsSecretForWordpress(wp, certPEM, keyPEM, ca.certPEM)
+	}
+	hosts := []string{"a.example.internal", "b.example.internal"}
+
+	renewAt, ok := certificateRenewal(secretFor(hosts, time.Now()), ca, []string{"b.example.internal", "a.example.internal"})
+	if !ok || !renewAt.After(time.Now().Add(50*24*time.Hour)) {
+		t.Errorf("fresh certificate is due for renewal at %v", renewAt)
+	}
+
+	renewAt, ok = certificateRenewal(secretFor(hosts, time.Now().Add(-70*24*time.Hour)), ca, hosts)
+	if !ok || renewAt.After(time.Now()) {
+		t.Errorf("certificate with 20 days left is not due for renewal, renewAt %v", renewAt)
+	}
+
+	if _, ok := certificateRenewal(secretFor(hosts[:1], time.Now()), ca, hosts); ok {
+		t.Error("certificate missing a host is kept")
+	}
+
+	otherData, err := newCertificateAuthority(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := parseCertificateAuthority(otherData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := certificateRenewal(secretFor(hosts, time.Now()), other, hosts); ok {
+		t.Error("certificate of another CA is kept")
+	}
+}
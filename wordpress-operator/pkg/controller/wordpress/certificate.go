@@ -0,0 +1,125 @@
+package wordpress
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+const (
+	// Defaults of spec.ingress.tls.issuerRef.
+	defaultIssuerKind  = "Issuer"
+	defaultIssuerGroup = "cert-manager.io"
+)
+
+// certificateGVK is the cert-manager Certificate. cert-manager is optional,
+// so Certificates are handled as unstructured objects and the operator does
+// not depend on its API packages.
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// ensureCertificate creates the cert-manager Certificate of m, or corrects
+// drift on it. The Certificate is deleted when spec.ingress.tls.issuerRef is
+// removed. Without cert-manager there is nothing to do; updateStatus reports
+// that the certificate cannot be issued.
+func (r *ReconcileWordpress) ensureCertificate(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	name := fmt.Sprintf("%s-wordpress", m.Name)
+	if issuerRef(m) == nil {
+		err := r.deleteIfExists(m, name, newCertificate(), reqLogger)
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+
+	cert := r.certificateForWordpress(m)
+	found := newCertificate()
+	_, err := r.ensure(cert, found, func() (bool, error) {
+		return r.applyChanges(found, cert)
+	}, reqLogger)
+	if meta.IsNoMatchError(err) {
+		reqLogger.Info("Cannot request a certificate; cert-manager is not installed", "Certificate.Name", name)
+		return nil
+	}
+	return err
+}
+
+// certificateForWordpress returns the Certificate covering every host of m,
+// which cert-manager stores in the Secret the Ingress of m is served with.
+func (r *ReconcileWordpress) certificateForWordpress(m *examplev1.Wordpress) *unstructured.Unstructured {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "frontend"
+
+	ref := issuerRef(m)
+	kind := ref.Kind
+	if kind == "" {
+		kind = defaultIssuerKind
+	}
+	group := ref.Group
+	if group == "" {
+		group = defaultIssuerGroup
+	}
+
+	cert := newCertificate()
+	cert.SetName(fmt.Sprintf("%s-wordpress", m.Name))
+	cert.SetNamespace(m.Namespace)
+	cert.SetLabels(ls)
+	unstructured.SetNestedField(cert.Object, tlsSecretName(m), "spec", "secretName")
+	unstructured.SetNestedStringSlice(cert.Object, m.Spec.Ingress.Hosts, "spec", "dnsNames")
+	unstructured.SetNestedStringMap(cert.Object, map[string]string{
+		"name":  ref.Name,
+		"kind":  kind,
+		"group": group,
+	}, "spec", "issuerRef")
+
+	controllerutil.SetControllerReference(m, cert, r.scheme)
+
+	return cert
+}
+
+func newCertificate() *unstructured.Unstructured {
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(certificateGVK)
+	return cert
+}
+
+// certificateReady reports whether cert-manager has issued cert, and
+// otherwise why not.
+func certificateReady(cert *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(cert.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		message, _ := cond["message"].(string)
+		return cond["status"] == "True", message
+	}
+	return false, fmt.Sprintf("Certificate %s has not been issued yet", cert.GetName())
+}
+
+// issuerRef returns the cert-manager issuer of the certificate of m, if any.
+func issuerRef(m *examplev1.Wordpress) *examplev1.IssuerReference {
+	if m.Spec.Ingress == nil || m.Spec.Ingress.TLS == nil {
+		return nil
+	}
+	return m.Spec.Ingress.TLS.IssuerRef
+}
+
+// tlsSecretName returns the Secret holding the certificate the site of m is
+// served with, or "" when it is served over plain HTTP.
+func tlsSecretName(m *examplev1.Wordpress) string {
+	if m.Spec.Ingress == nil || m.Spec.Ingress.TLS == nil {
+		return ""
+	}
+	tls := m.Spec.Ingress.TLS
+	if tls.SecretName == "" && (tls.IssuerRef != nil || tls.OperatorCA) {
+		return fmt.Sprintf("%s-tls", m.Name)
+	}
+	return tls.SecretName
+}
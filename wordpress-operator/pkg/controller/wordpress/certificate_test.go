@@ -0,0 +1,79 @@
+package wordpress
+
+import (
+	"context"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestReconcileRequestsCertificate(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Ingress: &examplev1.IngressSpec{
+				Hosts: []string{"blog.example.com", "www.blog.example.com"},
+				TLS: &examplev1.IngressTLSSpec{
+					IssuerRef: &examplev1.IssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"},
+				},
+			},
+		},
+	}
+	r := newTestReconciler(t, wp)
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	cert := newCertificate()
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, cert); err != nil {
+		t.Fatalf("Certificate was not created: %v", err)
+	}
+	if names, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames"); len(names) != 2 {
+		t.Errorf("dnsNames = %v, want every host", names)
+	}
+	if kind, _, _ := unstructured.NestedString(cert.Object, "spec", "issuerRef", "kind"); kind != "ClusterIssuer" {
+		t.Errorf("issuer kind = %q, want ClusterIssuer", kind)
+	}
+
+	// The Ingress is served with the Secret cert-manager writes.
+	ing := &networkingv1.Ingress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, ing); err != nil {
+		t.Fatal(err)
+	}
+	secret, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+	if len(ing.Spec.TLS) != 1 || ing.Spec.TLS[0].SecretName != secret || secret != "mysite-tls" {
+		t.Errorf("Ingress tls = %+v, Certificate secretName = %q; want both mysite-tls", ing.Spec.TLS, secret)
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	c := meta.FindStatusCondition(got.Status.Conditions, examplev1.ConditionCertificateReady)
+	if c == nil || c.Status == metav1.ConditionTrue {
+		t.Errorf("condition %s = %+v, want false until the certificate is issued", examplev1.ConditionCertificateReady, c)
+	}
+}
+
+func TestCertificateReady(t *testing.T) {
+	cert := newCertificate()
+	cert.SetName("mysite-wordpress")
+	if ready, _ := certificateReady(cert); ready {
+		t.Error("a Certificate without status is ready")
+	}
+
+	unstructured.SetNestedSlice(cert.Object, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": "True", "message": "Certificate is up to date and has not expired"},
+	}, "status", "conditions")
+	if ready, _ := certificateReady(cert); !ready {
+		t.Error("an issued Certificate is not ready")
+	}
+}
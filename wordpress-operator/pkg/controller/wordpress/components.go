@@ -0,0 +1,504 @@
+package wordpress
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// ensure creates desired when it does not exist yet, through the same
+// server-side apply that later corrects drift on it. Otherwise the existing
+// object is read into found and update is called to bring it in line with
+// desired. It reports whether desired was created.
+func (r *ReconcileWordpress) ensure(desired, found client.Object, update func() (bool, error), reqLogger logr.Logger) (bool, error) {
+	obj, err := meta.Accessor(desired)
+	if err != nil {
+		return false, err
+	}
+	gvk, err := apiutil.GVKForObject(desired, r.scheme)
+	if err != nil {
+		return false, err
+	}
+	kvs := []interface{}{gvk.Kind + ".Namespace", obj.GetNamespace(), gvk.Kind + ".Name", obj.GetName()}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, found)
+	if err != nil && errors.IsNotFound(err) {
+		reqLogger.Info("Creating a new "+gvk.Kind, kvs...)
+		err = r.apply(desired)
+		if err != nil {
+			reqLogger.Error(err, "Failed to create new "+gvk.Kind, kvs...)
+			return false, err
+		}
+		return true, nil
+	} else if err != nil {
+		reqLogger.Error(err, "Failed to get "+gvk.Kind, kvs...)
+		return false, err
+	}
+
+	updated, err := update()
+	if err != nil {
+		reqLogger.Error(err, "Failed to update "+gvk.Kind, kvs...)
+		return false, err
+	} else if updated {
+		reqLogger.Info("Updated "+gvk.Kind, kvs...)
+	}
+	return false, nil
+}
+
+// deleteIfExists deletes the named child of m, which obj is the type of,
+// when it exists. It is used for children the spec of m no longer asks for.
+func (r *ReconcileWordpress) deleteIfExists(m *examplev1.Wordpress, name string, obj client.Object, reqLogger logr.Logger) error {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: m.Namespace}, obj)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	gvk, err := apiutil.GVKForObject(obj, r.scheme)
+	if err != nil {
+		return err
+	}
+	reqLogger.Info("Deleting "+gvk.Kind+" that is no longer needed", gvk.Kind+".Namespace", m.Namespace, gvk.Kind+".Name", name)
+	err = r.client.Delete(context.TODO(), obj)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// ensureSecret creates the Secret holding the credentials of m, or applies
+// password changes to it and corrects drift. It returns the current Secret.
+func (r *ReconcileWordpress) ensureSecret(m *examplev1.Wordpress, reqLogger logr.Logger) (*corev1.Secret, error) {
+	// Read the database password, which may live in a Secret the user manages.
+	password, err := r.rootPasswordForWordpress(m)
+	if err != nil {
+		reqLogger.Error(err, "Failed to read database password")
+		return nil, err
+	}
+
+	found := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		data, err := credentialsForWordpress(password)
+		if err != nil {
+			reqLogger.Error(err, "Failed to generate credentials")
+			return nil, err
+		}
+		sec := r.secretForWordpress(m, data)
+		reqLogger.Info("Creating a new Secret", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name)
+		err = r.apply(sec)
+		if err != nil {
+			reqLogger.Error(err, "Failed to create new Secret", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name)
+			return nil, err
+		}
+		return sec, nil
+	} else if err != nil {
+		reqLogger.Error(err, "Failed to get Secret")
+		return nil, err
+	}
+
+	if err := r.adoptRetained(found, reqLogger); err != nil {
+		reqLogger.Error(err, "Failed to adopt Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+		return nil, err
+	}
+
+	// Apply password changes, add missing credentials and correct drift.
+	_, err = updateSecretCredentials(found, password, []byte(m.Spec.Password))
+	if err != nil {
+		reqLogger.Error(err, "Failed to generate credentials")
+		return nil, err
+	}
+	sec := r.secretForWordpress(m, found.Data)
+	updated, err := r.applyChanges(found, sec)
+	if err != nil {
+		reqLogger.Error(err, "Failed to update Secret", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name)
+		return nil, err
+	} else if updated {
+		reqLogger.Info("Updated Secret", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name)
+	}
+	return sec, nil
+}
+
+// ensureStorage creates the PVCs of m, or expands them and corrects drift.
+// There is no MySQL volume when the database is external. The claim of the
+// database is created ahead of its StatefulSet, which then uses it instead of
+// creating its own.
+// Resizes that cannot be applied and conflicts with immutable fields are
+// reported as conditions on m.
+func (r *ReconcileWordpress) ensureStorage(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	type claim struct {
+		desired *corev1.PersistentVolumeClaim
+		volume  examplev1.VolumeSpec
+	}
+	var claims []claim
+	if m.Spec.Database.External == nil {
+		names, err := r.databaseClaimNames(m)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			claims = append(claims, claim{r.mysqlPVCForWordpress(m, name), m.Spec.Storage.Database})
+		}
+		if err := r.removeReplicaClaims(m, reqLogger); err != nil {
+			return err
+		}
+	}
+	claims = append(claims, claim{r.wordpressPVCForWordpress(m), m.Spec.Storage.Wordpress})
+
+	var resizeRejections, conflicts []string
+	for _, pvc := range claims {
+		found := &corev1.PersistentVolumeClaim{}
+		_, err := r.ensure(pvc.desired, found, func() (bool, error) {
+			if err := r.adoptRetained(found, reqLogger); err != nil {
+				return false, err
+			}
+			conflicts = append(conflicts, immutablePVCConflicts(found, pvc.volume)...)
+			updated, err := r.applyPVC(found, pvc.desired)
+			if rejected, ok := err.(*resizeRejectedError); ok {
+				reqLogger.Info("Rejected PVC resize", "PVC.Name", found.Name, "Reason", rejected.Error())
+				resizeRejections = append(resizeRejections, rejected.Error())
+				return updated, nil
+			}
+			return updated, err
+		}, reqLogger)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(resizeRejections) > 0 {
+		meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+			Type:               examplev1.ConditionStorageResizeFailed,
+			ObservedGeneration: m.Generation,
+			Status:             metav1.ConditionTrue,
+			Reason:             "ResizeRejected",
+			Message:            strings.Join(resizeRejections, "; "),
+		})
+	} else {
+		meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+			Type:               examplev1.ConditionStorageResizeFailed,
+			ObservedGeneration: m.Generation,
+			Status:             metav1.ConditionFalse,
+			Reason:             "SizeMatchesSpec",
+		})
+	}
+	// Immutable fields are reported rather than fought over.
+	if len(conflicts) > 0 {
+		meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+			Type:               examplev1.ConditionImmutableFieldConflict,
+			ObservedGeneration: m.Generation,
+			Status:             metav1.ConditionTrue,
+			Reason:             "SpecDiffersFromImmutableField",
+			Message:            strings.Join(conflicts, "; "),
+		})
+	} else {
+		meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+			Type:               examplev1.ConditionImmutableFieldConflict,
+			ObservedGeneration: m.Generation,
+			Status:             metav1.ConditionFalse,
+			Reason:             "NoConflict",
+		})
+	}
+	return nil
+}
+
+// addImmutableFieldConflict adds message to the ImmutableFieldConflict
+// condition of m, which ensureStorage sets first in each reconcile.
+func addImmutableFieldConflict(m *examplev1.Wordpress, message string) {
+	if c := meta.FindStatusCondition(m.Status.Conditions, examplev1.ConditionImmutableFieldConflict); c != nil && c.Status == metav1.ConditionTrue {
+		message = c.Message + "; " + message
+	}
+	meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+		Type:               examplev1.ConditionImmutableFieldConflict,
+		ObservedGeneration: m.Generation,
+		Status:             metav1.ConditionTrue,
+		Reason:             "SpecDiffersFromImmutableField",
+		Message:            message,
+	})
+}
+
+// ensureDatabase creates the MySQL StatefulSet and Services of m, or corrects
+// drift on them. A change of engine, and read replicas on a site that keeps
+// its legacy claim, are reported as conflicts and not applied.
+// It returns the StatefulSet as found before this reconcile, or nil when it
+// was just created or is waiting for the Deployment it replaces to go away.
+func (r *ReconcileWordpress) ensureDatabase(m *examplev1.Wordpress, reqLogger logr.Logger) (*appsv1.StatefulSet, error) {
+	claim, err := r.databaseClaimName(m)
+	if err != nil {
+		return nil, err
+	}
+	legacyClaim := ""
+	if claim == legacyDatabaseClaimName(m) {
+		legacyClaim = claim
+	}
+
+	gone, err := r.removeDatabaseDeployment(m, reqLogger)
+	if err != nil || !gone {
+		return nil, err
+	}
+
+	if legacyClaim != "" && m.Spec.Database.Replicas > 0 {
+		addImmutableFieldConflict(m, fmt.Sprintf("claim %s predates the StatefulSet and cannot hold read replicas", legacyClaim))
+	}
+
+	sts := r.mysqlStatefulSetForWordpress(m, legacyClaim)
+	found := &appsv1.StatefulSet{}
+	created, err := r.ensure(sts, found, func() (bool, error) {
+		// The data directory belongs to the engine that initialized it.
+		if engine := podEngine(found.Spec.Template); engine != engineName(m) {
+			addImmutableFieldConflict(m, fmt.Sprintf("StatefulSet %s runs %s, not %s", found.Name, engine, engineName(m)))
+			return false, nil
+		}
+		return r.applyStatefulSet(found, sts)
+	}, reqLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, svc := range []*corev1.Service{r.mysqlHeadlessServiceForWordpress(m), r.mysqlServiceForWordpress(m), r.mysqlReadServiceForWordpress(m)} {
+		foundSvc := &corev1.Service{}
+		_, err = r.ensure(svc, foundSvc, func() (bool, error) {
+			return r.applyChanges(foundSvc, svc)
+		}, reqLogger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if created {
+		return nil, nil
+	}
+	return found, nil
+}
+
+// databaseClaimName returns the name of the claim holding the database of m.
+// Sites created before the database ran as a StatefulSet keep the claim their
+// Deployment used; newer sites use the claim of the volumeClaimTemplate.
+func (r *ReconcileWordpress) databaseClaimName(m *examplev1.Wordpress) (string, error) {
+	legacy := legacyDatabaseClaimName(m)
+	templated := databasePodClaimName(m, 0)
+
+	sts := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-mysql", m.Name), Namespace: m.Namespace}, sts)
+	if err == nil {
+		if len(sts.Spec.VolumeClaimTemplates) == 0 {
+			return legacy, nil
+		}
+		return templated, nil
+	} else if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: legacy, Namespace: m.Namespace}, &corev1.PersistentVolumeClaim{})
+	if err == nil {
+		return legacy, nil
+	} else if !errors.IsNotFound(err) {
+		return "", err
+	}
+	return templated, nil
+}
+
+func legacyDatabaseClaimName(m *examplev1.Wordpress) string {
+	return fmt.Sprintf("%s-mysql", m.Name)
+}
+
+// databaseClaimNames returns the names of the claims holding the databases of
+// m: the primary's first, then one for each read replica m asks for. A legacy
+// claim only ever holds the primary.
+func (r *ReconcileWordpress) databaseClaimNames(m *examplev1.Wordpress) ([]string, error) {
+	primary, err := r.databaseClaimName(m)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{primary}
+	if primary == legacyDatabaseClaimName(m) {
+		return names, nil
+	}
+	for i := int32(1); i <= m.Spec.Database.Replicas; i++ {
+		names = append(names, databasePodClaimName(m, i))
+	}
+	return names, nil
+}
+
+// databasePodClaimName returns the name of the claim the volumeClaimTemplate
+// of the MySQL StatefulSet gives the database pod with the given ordinal.
+func databasePodClaimName(m *examplev1.Wordpress, ordinal int32) string {
+	return fmt.Sprintf("%s-%s", mysqlDataVolume, replicaPodName(m, ordinal))
+}
+
+// replicaClaims returns the claims of read replicas of m found in its
+// namespace, whether m still asks for them or not, by ordinal.
+func (r *ReconcileWordpress) replicaClaims(m *examplev1.Wordpress) (map[int32]*corev1.PersistentVolumeClaim, error) {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "mysql"
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	err := r.client.List(context.TODO(), pvcs, client.InNamespace(m.Namespace), client.MatchingLabels(ls))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := fmt.Sprintf("%s-%s-mysql-", mysqlDataVolume, m.Name)
+	claims := map[int32]*corev1.PersistentVolumeClaim{}
+	for i := range pvcs.Items {
+		if !strings.HasPrefix(pvcs.Items[i].Name, prefix) {
+			continue
+		}
+		ordinal, err := strconv.ParseInt(strings.TrimPrefix(pvcs.Items[i].Name, prefix), 10, 32)
+		if err != nil || ordinal < 1 {
+			continue
+		}
+		claims[int32(ordinal)] = &pvcs.Items[i]
+	}
+	return claims, nil
+}
+
+// removeReplicaClaims deletes the claims of read replicas that m no longer
+// asks for, once the StatefulSet has removed their pods. A replica that is
+// scaled back up is seeded afresh. Claims kept by the Retain deletion policy
+// of an earlier site are left alone.
+func (r *ReconcileWordpress) removeReplicaClaims(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	claims, err := r.replicaClaims(m)
+	if err != nil {
+		return err
+	}
+	for ordinal, pvc := range claims {
+		if ordinal <= m.Spec.Database.Replicas || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if _, retained := pvc.Labels[retainedLabel]; retained {
+			continue
+		}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: replicaPodName(m, ordinal), Namespace: m.Namespace}, &corev1.Pod{})
+		if err == nil {
+			continue
+		} else if !errors.IsNotFound(err) {
+			return err
+		}
+		reqLogger.Info("Deleting the PVC of a removed replica", "PVC.Name", pvc.Name)
+		err = r.client.Delete(context.TODO(), pvc)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDatabaseDeployment deletes the Deployment that ran the database of m
+// before it ran as a StatefulSet. Its pods are gone before the Deployment is,
+// so the StatefulSet is only created once nothing else uses the data
+// directory. It reports whether the Deployment is gone.
+func (r *ReconcileWordpress) removeDatabaseDeployment(m *examplev1.Wordpress, reqLogger logr.Logger) (bool, error) {
+	dep := &appsv1.Deployment{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-mysql", m.Name), Namespace: m.Namespace}, dep)
+	if errors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if dep.DeletionTimestamp == nil {
+		reqLogger.Info("Replacing the mysql Deployment with a StatefulSet", "Deployment.Name", dep.Name)
+		err = r.client.Delete(context.TODO(), dep, client.PropagationPolicy(metav1.DeletePropagationForeground))
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	reqLogger.Info("Waiting for the mysql Deployment to be deleted", "Deployment.Name", dep.Name)
+	return false, nil
+}
+
+// ensureWordpress creates the WordPress Deployment and Service of m, or
+// corrects drift on them. A new WordPress image is only rolled out once
+// mysqlSts is done rolling out, since a new WordPress may upgrade the database
+// schema; an external database is not rolled out by the operator. A
+// WordPress that still connects as root keeps doing so until the application
+// user is ready. It reports whether it is waiting for either.
+// More than one WordPress pod only runs on a volume they can all mount;
+// otherwise the site runs a single pod and reports that scaling is blocked.
+func (r *ReconcileWordpress) ensureWordpress(m *examplev1.Wordpress, mysqlSts *appsv1.StatefulSet, userReady bool, reqLogger logr.Logger) (bool, error) {
+	blocked, err := r.scalingBlocked(m)
+	if err != nil {
+		return false, err
+	}
+
+	waiting := false
+	dep := r.wordpressDeploymentForWordpress(m)
+	if blocked {
+		single := int32(1)
+		dep.Spec.Replicas = &single
+	}
+	found := &appsv1.Deployment{}
+	_, err = r.ensure(dep, found, func() (bool, error) {
+		if containerImage(found.Spec.Template, "wordpress") != wordpressImage(m) && m.Spec.Database.External == nil && (mysqlSts == nil || !statefulSetRolledOut(mysqlSts)) {
+			reqLogger.Info("Waiting for mysql rollout before updating wordpress", "wordpressDep.Name", found.Name)
+			waiting = true
+			return false, nil
+		}
+		if !userReady && !usesDatabaseUser(found) {
+			reqLogger.Info("Waiting for the mysql application user before updating wordpress", "wordpressDep.Name", found.Name)
+			waiting = true
+			return false, nil
+		}
+		return r.applyDeployment(found, dep)
+	}, reqLogger)
+	if err != nil {
+		return waiting, err
+	}
+
+	if err := r.ensureAutoscaler(m, blocked, reqLogger); err != nil {
+		return waiting, err
+	}
+
+	svc := r.wordpressServiceForWordpress(m)
+	foundSvc := &corev1.Service{}
+	_, err = r.ensure(svc, foundSvc, func() (bool, error) {
+		return r.applyService(foundSvc, svc)
+	}, reqLogger)
+	if err != nil {
+		return waiting, err
+	}
+
+	return waiting, r.ensureIngress(m, reqLogger)
+}
+
+// scalingBlocked reports whether m asks for more WordPress pods than its
+// WordPress volume allows, and records the answer as a condition on m.
+func (r *ReconcileWordpress) scalingBlocked(m *examplev1.Wordpress) (bool, error) {
+	pvc := r.wordpressPVCForWordpress(m)
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, pvc)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	if wordpressMaxReplicas(m) <= 1 || sharedStorage(pvc) {
+		meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+			Type:               examplev1.ConditionScalingBlocked,
+			ObservedGeneration: m.Generation,
+			Status:             metav1.ConditionFalse,
+			Reason:             "StorageAllowsScaling",
+		})
+		return false, nil
+	}
+	meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+		Type:               examplev1.ConditionScalingBlocked,
+		ObservedGeneration: m.Generation,
+		Status:             metav1.ConditionTrue,
+		Reason:             "StorageNotShared",
+		Message: fmt.Sprintf("PersistentVolumeClaim %s is not ReadWriteMany, so a single WordPress pod runs instead of up to %d",
+			pvc.Name, wordpressMaxReplicas(m)),
+	})
+	return true, nil
+}
@@ -0,0 +1,230 @@
+package wordpress
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// Keys of the credentials stored in the Secret owned by each Wordpress.
+	// WordPress connects to the database named under secretDatabaseKey as the
+	// user named under secretUsernameKey, whose password is secretAppPasswordKey.
+	secretRootPasswordKey = "password"
+	secretAppPasswordKey  = "app-password"
+	secretDatabaseKey     = "database"
+	secretUsernameKey     = "username"
+	// secretReplicationPasswordKey is the password database replicas use to
+	// connect to the primary.
+	secretReplicationPasswordKey = "replication-password"
+	// secretPreviousRootPasswordKey holds the root password MySQL still uses
+	// while a change to the root password is being rolled out.
+	secretPreviousRootPasswordKey = "previous-password"
+
+	// secretEncodingAnnotation records how the credentials in the Secret are
+	// stored. Secrets without it were written by operator versions that
+	// base64-encoded each value before storing it, so the value MySQL saw was
+	// the encoded string rather than the configured password.
+	secretEncodingAnnotation = "example.com/secret-encoding"
+	secretEncodingRaw        = "raw"
+
+	// Length and alphabet of generated passwords. The alphabet avoids
+	// characters that need quoting in shells or MySQL statements.
+	generatedPasswordLength   = 32
+	generatedPasswordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// Names of the database and the application user of each site. Every
+	// site has a MySQL server of its own, so they do not need to be unique.
+	defaultDatabaseName = "wordpress"
+	defaultUsername     = "wordpress"
+)
+
+// rootPasswordForWordpress returns the MySQL root password of m. It is read from
+// spec.database.passwordSecretRef when set, falling back to the deprecated
+// plaintext spec.sqlRootPassword. An empty password means none is configured.
+func (r *ReconcileWordpress) rootPasswordForWordpress(m *examplev1.Wordpress) ([]byte, error) {
+	ref := m.Spec.Database.PasswordSecretRef
+	if ref == nil {
+		return []byte(m.Spec.Password), nil
+	}
+
+	sec := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: m.Namespace}, sec)
+	if err != nil {
+		return nil, fmt.Errorf("reading passwordSecretRef: %w", err)
+	}
+	pw, ok := sec.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("passwordSecretRef: Secret %s has no key %q", ref.Name, ref.Key)
+	}
+	return pw, nil
+}
+
+// requestsForSecret maps a Secret to the Wordpresses in its namespace that
+// reference it through spec.database.passwordSecretRef or
+// spec.database.external.credentialsSecretRef.
+func requestsForSecret(c client.Client, a client.Object) []reconcile.Request {
+	list := &examplev1.WordpressList{}
+	err := c.List(context.TODO(), list, client.InNamespace(a.GetNamespace()))
+	if err != nil {
+		log.Error(err, "Failed to list Wordpresses", "Secret.Namespace", a.GetNamespace(), "Secret.Name", a.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, wp := range list.Items {
+		ref := wp.Spec.Database.PasswordSecretRef
+		ext := wp.Spec.Database.External
+		if (ref != nil && ref.Name == a.GetName()) || (ext != nil && ext.CredentialsSecretRef.Name == a.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: wp.Name, Namespace: wp.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// credentialsForWordpress returns the credentials of a new site. When no root
+// password is configured, a random one is generated. The application and
+// replication passwords are always generated.
+func credentialsForWordpress(rootPassword []byte) (map[string][]byte, error) {
+	var err error
+	if len(rootPassword) == 0 {
+		rootPassword, err = generatePassword()
+		if err != nil {
+			return nil, err
+		}
+	}
+	appPassword, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+	replicationPassword, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		secretRootPasswordKey:        rootPassword,
+		secretAppPasswordKey:         appPassword,
+		secretReplicationPasswordKey: replicationPassword,
+		secretDatabaseKey:            []byte(defaultDatabaseName),
+		secretUsernameKey:            []byte(defaultUsername),
+	}, nil
+}
+
+// secretForWordpress returns the Secret holding the credentials of m.
+func (r *ReconcileWordpress) secretForWordpress(m *examplev1.Wordpress, data map[string][]byte) *corev1.Secret {
+	ls := labelsForWordpress(m.Name)
+
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        m.Name,
+			Namespace:   m.Namespace,
+			Labels:      ls,
+			Annotations: map[string]string{secretEncodingAnnotation: secretEncodingRaw},
+		},
+		Data: data,
+	}
+
+	// Set Wordpress instance as the owner and controller
+	controllerutil.SetControllerReference(m, sec, r.scheme)
+
+	return sec
+}
+
+// updateSecretCredentials applies a configured root password to sec and adds
+// any credential sec is missing. Credentials that were generated earlier are
+// never replaced. Secrets written by older operator versions, which stored
+// legacyPassword encoded, are migrated to the raw encoding. It reports whether
+// sec was changed.
+//
+// When the root password changes, the password MySQL still uses is kept under
+// secretPreviousRootPasswordKey until the change has been applied to MySQL.
+func updateSecretCredentials(sec *corev1.Secret, rootPassword, legacyPassword []byte) (bool, error) {
+	if sec.Data == nil {
+		sec.Data = map[string][]byte{}
+	}
+
+	changed := false
+	if sec.Annotations[secretEncodingAnnotation] != secretEncodingRaw {
+		migrateSecretEncoding(sec, legacyPassword)
+		changed = true
+	}
+	if len(rootPassword) > 0 && !bytes.Equal(sec.Data[secretRootPasswordKey], rootPassword) {
+		setRootPassword(sec, rootPassword)
+		changed = true
+	}
+	for _, key := range []string{secretRootPasswordKey, secretAppPasswordKey, secretReplicationPasswordKey} {
+		if len(sec.Data[key]) > 0 {
+			continue
+		}
+		pw, err := generatePassword()
+		if err != nil {
+			return false, err
+		}
+		sec.Data[key] = pw
+		changed = true
+	}
+	for key, name := range map[string]string{secretDatabaseKey: defaultDatabaseName, secretUsernameKey: defaultUsername} {
+		if len(sec.Data[key]) == 0 {
+			sec.Data[key] = []byte(name)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// generatePassword returns a cryptographically random password.
+func generatePassword() ([]byte, error) {
+	max := big.NewInt(int64(len(generatedPasswordAlphabet)))
+	pw := make([]byte, generatedPasswordLength)
+	for i := range pw {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, fmt.Errorf("generating password: %w", err)
+		}
+		pw[i] = generatedPasswordAlphabet[n.Int64()]
+	}
+	return pw, nil
+}
+
+// migrateSecretEncoding decodes the root password written by older operator
+// versions, which stored legacyPassword, the plaintext spec.sqlRootPassword,
+// base64-encoded. MySQL was initialized with the encoded root password, so it
+// is scheduled to be changed to the decoded one. A value is only taken to be
+// encoded when it is exactly the encoding of legacyPassword: generated
+// passwords are valid base64 too, and must be kept as they are.
+func migrateSecretEncoding(sec *corev1.Secret, legacyPassword []byte) {
+	enc := sec.Data[secretRootPasswordKey]
+	if len(legacyPassword) > 0 && string(enc) == base64.StdEncoding.EncodeToString(legacyPassword) {
+		setRootPassword(sec, legacyPassword)
+	}
+
+	if sec.Annotations == nil {
+		sec.Annotations = map[string]string{}
+	}
+	sec.Annotations[secretEncodingAnnotation] = secretEncodingRaw
+}
+
+// setRootPassword replaces the root password in sec, remembering the password
+// MySQL currently uses unless an earlier change is still pending.
+func setRootPassword(sec *corev1.Secret, pw []byte) {
+	if _, pending := sec.Data[secretPreviousRootPasswordKey]; !pending {
+		if current := sec.Data[secretRootPasswordKey]; len(current) > 0 {
+			sec.Data[secretPreviousRootPasswordKey] = current
+		}
+	}
+	sec.Data[secretRootPasswordKey] = pw
+}
@@ -0,0 +1,226 @@
+package wordpress
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestUpdateSecretCredentialsMigratesLegacyEncoding(t *testing.T) {
+	legacy := base64.StdEncoding.EncodeToString([]byte("plaintextpassword"))
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite"},
+		Data:       map[string][]byte{secretRootPasswordKey: []byte(legacy)},
+	}
+
+	changed, err := updateSecretCredentials(sec, []byte("plaintextpassword"), []byte("plaintextpassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("expected the legacy Secret to be changed")
+	}
+	if got := string(sec.Data[secretRootPasswordKey]); got != "plaintextpassword" {
+		t.Errorf("root password = %q, want the decoded password", got)
+	}
+	// MySQL was initialized with the encoded string, which is needed to log in and change it.
+	if got := string(sec.Data[secretPreviousRootPasswordKey]); got != legacy {
+		t.Errorf("previous root password = %q, want %q", got, legacy)
+	}
+	if sec.Annotations[secretEncodingAnnotation] != secretEncodingRaw {
+		t.Errorf("expected the %s annotation to be set", secretEncodingAnnotation)
+	}
+	if len(sec.Data[secretAppPasswordKey]) == 0 {
+		t.Error("expected an application password to be generated")
+	}
+	if string(sec.Data[secretDatabaseKey]) != defaultDatabaseName || string(sec.Data[secretUsernameKey]) != defaultUsername {
+		t.Error("expected the database and application user to be named")
+	}
+
+	// A second pass finds nothing to do and keeps the generated password.
+	appPassword := string(sec.Data[secretAppPasswordKey])
+	changed, err = updateSecretCredentials(sec, []byte("plaintextpassword"), []byte("plaintextpassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || string(sec.Data[secretAppPasswordKey]) != appPassword {
+		t.Error("expected the migrated Secret to be left alone")
+	}
+}
+
+func TestUpdateSecretCredentialsKeepsUnannotatedGeneratedPassword(t *testing.T) {
+	// Generated passwords are valid base64, but were never encoded.
+	generated, err := generatePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base64.StdEncoding.DecodeString(string(generated)); err != nil {
+		t.Fatalf("test needs a password that decodes as base64: %v", err)
+	}
+	for _, legacy := range []string{"", "plaintextpassword"} {
+		sec := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "mysite"},
+			Data:       map[string][]byte{secretRootPasswordKey: generated},
+		}
+		if _, err := updateSecretCredentials(sec, nil, []byte(legacy)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sec.Data[secretRootPasswordKey], generated) {
+			t.Errorf("legacy password %q: root password = %q, want the generated one kept", legacy, sec.Data[secretRootPasswordKey])
+		}
+		if _, pending := sec.Data[secretPreviousRootPasswordKey]; pending {
+			t.Errorf("legacy password %q: a root password change was scheduled", legacy)
+		}
+		if sec.Annotations[secretEncodingAnnotation] != secretEncodingRaw {
+			t.Errorf("expected the %s annotation to be set", secretEncodingAnnotation)
+		}
+	}
+}
+
+func TestUpdateSecretCredentialsKeepsGeneratedPassword(t *testing.T) {
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mysite",
+			Annotations: map[string]string{secretEncodingAnnotation: secretEncodingRaw},
+		},
+		Data: map[string][]byte{
+			secretRootPasswordKey:        []byte("generated-root"),
+			secretAppPasswordKey:         []byte("generated-app"),
+			secretReplicationPasswordKey: []byte("generated-replication"),
+			secretDatabaseKey:            []byte("wordpress"),
+			secretUsernameKey:            []byte("wordpress"),
+		},
+	}
+
+	changed, err := updateSecretCredentials(sec, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("expected no change when no password is configured")
+	}
+	if string(sec.Data[secretRootPasswordKey]) != "generated-root" {
+		t.Error("generated root password was replaced")
+	}
+}
+
+func TestCredentialsForWordpressGeneratesPasswords(t *testing.T) {
+	data, err := credentialsForWordpress(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, app := data[secretRootPasswordKey], data[secretAppPasswordKey]
+	if len(root) != generatedPasswordLength || len(app) != generatedPasswordLength {
+		t.Errorf("passwords of %d and %d bytes, want strong generated ones", len(root), len(app))
+	}
+	if bytes.Equal(root, app) {
+		t.Error("the application shares the root password")
+	}
+
+	other, err := credentialsForWordpress(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(other[secretRootPasswordKey], root) || bytes.Equal(other[secretAppPasswordKey], app) {
+		t.Error("two sites were given the same password")
+	}
+
+	// A configured root password is used as is.
+	data, err = credentialsForWordpress([]byte("configured"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[secretRootPasswordKey]) != "configured" || len(data[secretAppPasswordKey]) == 0 {
+		t.Errorf("credentials = %v, want the configured root password and a generated application one", data)
+	}
+}
+
+func TestEnsureSecretKeepsGeneratedCredentials(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	reqLogger := log.WithValues("Request.Name", "mysite")
+
+	created, err := r.ensureSecret(wp, reqLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ensureSecret(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{secretRootPasswordKey, secretAppPasswordKey, secretReplicationPasswordKey} {
+		if len(got.Data[key]) == 0 || !bytes.Equal(got.Data[key], created.Data[key]) {
+			t.Errorf("%s was regenerated on the second reconcile", key)
+		}
+	}
+	if _, rotating := got.Data[secretPreviousRootPasswordKey]; rotating {
+		t.Error("the second reconcile started a root password change")
+	}
+}
+
+func TestEnsureSecretReadsPasswordSecretRef(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Database: examplev1.DatabaseSpec{
+				PasswordSecretRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "db-root"},
+					Key:                  "root",
+				},
+			},
+		},
+	}
+	ref := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-root", Namespace: "default"},
+		Data:       map[string][]byte{"root": []byte("from-the-secret")},
+	}
+	r := newTestReconciler(t, wp, ref)
+	reqLogger := log.WithValues("Request.Name", "mysite")
+
+	sec, err := r.ensureSecret(wp, reqLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sec.Data[secretRootPasswordKey]) != "from-the-secret" {
+		t.Errorf("root password = %q, want the one in passwordSecretRef", sec.Data[secretRootPasswordKey])
+	}
+
+	// Editing the referenced Secret reconciles the site, which changes the password.
+	requests := requestsForSecret(r.client, ref)
+	if len(requests) != 1 || requests[0].Name != "mysite" {
+		t.Errorf("requests for the referenced Secret = %v, want mysite", requests)
+	}
+	if requests := requestsForSecret(r.client, sec); len(requests) != 0 {
+		t.Errorf("requests for an unreferenced Secret = %v, want none", requests)
+	}
+	ref.Data["root"] = []byte("edited")
+	if err := r.client.Update(context.TODO(), ref); err != nil {
+		t.Fatal(err)
+	}
+	sec, err = r.ensureSecret(wp, reqLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sec.Data[secretRootPasswordKey]) != "edited" || string(sec.Data[secretPreviousRootPasswordKey]) != "from-the-secret" {
+		t.Errorf("root passwords = %q, previous %q; want the edit pending", sec.Data[secretRootPasswordKey], sec.Data[secretPreviousRootPasswordKey])
+	}
+
+	// A missing key is an error rather than a generated password.
+	wp.Spec.Database.PasswordSecretRef.Key = "missing"
+	if _, err := r.ensureSecret(wp, reqLogger); err == nil {
+		t.Error("ensureSecret succeeded with a key the Secret does not have")
+	}
+}
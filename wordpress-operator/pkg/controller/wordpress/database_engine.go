@@ -0,0 +1,83 @@
+package wordpress
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// databaseEngine describes how to run a database server image.
+type databaseEngine struct {
+	// defaultImage is used when the Wordpress spec does not name an image.
+	defaultImage string
+	// envPrefix starts the names of the variables the image is initialized from.
+	envPrefix string
+	// dataDir is where the server keeps its data.
+	dataDir string
+	// server and client are the commands of the server and of its client.
+	server string
+	client string
+	// admin is the administration client, which can ping the server.
+	admin string
+	// clientAliases is a shell preamble that maps the mysql client commands
+	// the Job scripts use to the commands the image ships.
+	clientAliases string
+}
+
+var databaseEngines = map[examplev1.DatabaseEngine]databaseEngine{
+	examplev1.DatabaseEngineMySQL: {
+		defaultImage: "mysql:8.4",
+		envPrefix:    "MYSQL_",
+		dataDir:      "/var/lib/mysql",
+		server:       "mysqld",
+		client:       "mysql",
+		admin:        "mysqladmin",
+	},
+	// MariaDB 11 no longer ships the mysql* names of its clients.
+	examplev1.DatabaseEngineMariaDB: {
+		defaultImage: "mariadb:10.11",
+		envPrefix:    "MARIADB_",
+		dataDir:      "/var/lib/mysql",
+		server:       "mariadbd",
+		client:       "mariadb",
+		admin:        "mariadb-admin",
+		clientAliases: `mysql() { mariadb "$@"; }
+mysqladmin() { mariadb-admin "$@"; }
+mysqldump() { mariadb-dump "$@"; }
+`,
+	},
+}
+
+// engineForWordpress returns the database engine m runs.
+func engineForWordpress(m *examplev1.Wordpress) databaseEngine {
+	return databaseEngines[engineName(m)]
+}
+
+func engineName(m *examplev1.Wordpress) examplev1.DatabaseEngine {
+	if m.Spec.Database.Engine == "" {
+		return examplev1.DatabaseEngineMySQL
+	}
+	return m.Spec.Database.Engine
+}
+
+// podEngine returns the engine the database pod template t was created for,
+// which is told apart by how its server is initialized.
+func podEngine(t corev1.PodTemplateSpec) examplev1.DatabaseEngine {
+	for _, c := range t.Spec.Containers {
+		for _, e := range c.Env {
+			if e.Name == databaseEngines[examplev1.DatabaseEngineMariaDB].envPrefix+"ROOT_PASSWORD" {
+				return examplev1.DatabaseEngineMariaDB
+			}
+		}
+	}
+	return examplev1.DatabaseEngineMySQL
+}
+
+// databaseScript prepares script to run with the database clients of the
+// engine of m.
+func databaseScript(m *examplev1.Wordpress, script string) string {
+	if m.Spec.Database.External != nil {
+		return script
+	}
+	return engineForWordpress(m).clientAliases + script
+}
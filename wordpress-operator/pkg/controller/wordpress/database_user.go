@@ -0,0 +1,82 @@
+package wordpress
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// createDatabaseUserScript creates the database $APP_DATABASE and the user
+// $APP_USER with password $APP_PASSWORD, and grants the user every privilege
+// on that database and nothing else. It can be run any number of times.
+// CREATE USER IF NOT EXISTS is tried first and GRANT ... IDENTIFIED BY is the
+// fallback for MySQL 5.6. The names are limited to characters that need no
+// quoting.
+const createDatabaseUserScript = `set -e
+for name in "$APP_DATABASE" "$APP_USER"; do
+  case "$name" in
+    ''|*[!A-Za-z0-9_]*) echo "invalid database or user name: $name" >&2; exit 1 ;;
+  esac
+done
+until mysqladmin -h "$MYSQL_HOST" ping --silent; do sleep 2; done
+PW=$(printf '%s' "$APP_PASSWORD" | sed -e 's/\\/\\\\/g' -e "s/'/\\\\'/g")
+mysql -h "$MYSQL_HOST" -uroot -e "CREATE DATABASE IF NOT EXISTS $APP_DATABASE"
+if mysql -h "$MYSQL_HOST" -uroot -e "CREATE USER IF NOT EXISTS '$APP_USER'@'%' IDENTIFIED BY '$PW'"; then
+  mysql -h "$MYSQL_HOST" -uroot -e "ALTER USER '$APP_USER'@'%' IDENTIFIED BY '$PW'"
+else
+  mysql -h "$MYSQL_HOST" -uroot -e "GRANT USAGE ON *.* TO '$APP_USER'@'%' IDENTIFIED BY '$PW'"
+fi
+mysql -h "$MYSQL_HOST" -uroot -e "GRANT ALL PRIVILEGES ON $APP_DATABASE.* TO '$APP_USER'@'%'"
+echo "database user is set up"
+`
+
+// ensureDatabaseUser makes sure MySQL has the database and the application
+// user WordPress connects as. MySQL only creates them itself when it
+// initializes an empty data directory, so sites created by older operator
+// versions, and sites that adopt a retained volume, need a Job to create them.
+// The completed Job is kept as the record that the user exists. It reports
+// whether the user is ready.
+func (r *ReconcileWordpress) ensureDatabaseUser(m *examplev1.Wordpress, reqLogger logr.Logger) (bool, error) {
+	return r.runJob(r.databaseUserJobForWordpress(m), "create the MySQL application user", reqLogger)
+}
+
+func (r *ReconcileWordpress) databaseUserJobForWordpress(m *examplev1.Wordpress) *batchv1.Job {
+	return r.newJob(m, fmt.Sprintf("%s-mysql-user", m.Name), jobLabelsForWordpress(m.Name, "database-user"), 600, corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Image:   mysqlImage(m),
+			Name:    "create-user",
+			Command: []string{"sh", "-c", databaseScript(m, createDatabaseUserScript)},
+			Env: []corev1.EnvVar{
+				{
+					Name:  "MYSQL_HOST",
+					Value: fmt.Sprintf("%s-mysql", m.Name),
+				},
+				secretEnvVar("MYSQL_PWD", m.Name, secretRootPasswordKey),
+				secretEnvVar("APP_DATABASE", m.Name, secretDatabaseKey),
+				secretEnvVar("APP_USER", m.Name, secretUsernameKey),
+				secretEnvVar("APP_PASSWORD", m.Name, secretAppPasswordKey),
+			},
+		}},
+	})
+}
+
+// usesDatabaseUser reports whether the WordPress container of dep connects
+// as the application user rather than as root.
+func usesDatabaseUser(dep *appsv1.Deployment) bool {
+	for _, c := range dep.Spec.Template.Spec.Containers {
+		if c.Name != "wordpress" {
+			continue
+		}
+		for _, e := range c.Env {
+			if e.Name == "WORDPRESS_DB_USER" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,411 @@
+package wordpress
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// deletionFinalizer holds back the deletion of a Wordpress until its
+// deletion policy has been carried out.
+const deletionFinalizer = "example.com/deletion-policy"
+
+// retainedLabel marks objects kept by the Retain deletion policy. Its value
+// is the name of the Wordpress they belonged to.
+const retainedLabel = "example.com/retained-from"
+
+// backupLabel marks the claim holding the final backup of a site. Its value
+// is the name of the Wordpress it was taken from.
+const backupLabel = "example.com/backup-of"
+
+// backupHoldLabel marks the objects the final backup of a site needs, which
+// are released from the Wordpress while the backup runs so that the garbage
+// collector cannot delete them first. Its value is the name of the Wordpress.
+const backupHoldLabel = "example.com/held-for-backup"
+
+// finalBackupScript writes a dump of the databases selected by $DUMP_ARGS and
+// an archive of the WordPress files to a new timestamped directory under
+// /backup. A directory is only renamed into place once it is complete, so
+// retries never leave a partial backup behind that looks finished.
+const finalBackupScript = `set -e
+until mysqladmin -h "$DB_HOST" -P "$DB_PORT" ping --silent; do sleep 2; done
+dir=/backup/$(date -u +%Y%m%dT%H%M%SZ)
+mkdir -p "$dir.partial"
+mysqldump -h "$DB_HOST" -P "$DB_PORT" -u"$DB_USER" --single-transaction $DUMP_ARGS > "$dir.partial/database.sql"
+tar -C /var/www/html -czf "$dir.partial/wordpress.tar.gz" .
+mv "$dir.partial" "$dir"
+echo "backup written to $dir"
+`
+
+// ensureFinalizer adds the deletion finalizer to m, so that its deletion
+// policy is carried out before its objects are garbage collected.
+func (r *ReconcileWordpress) ensureFinalizer(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	if hasFinalizer(m, deletionFinalizer) {
+		return nil
+	}
+	reqLogger.Info("Adding finalizer", "Finalizer", deletionFinalizer)
+	controllerutil.AddFinalizer(m, deletionFinalizer)
+	return r.client.Update(context.TODO(), m)
+}
+
+// finalizeWordpress carries out the deletion policy of m, which is being
+// deleted, and then removes the deletion finalizer so that the garbage
+// collector deletes whatever is still owned by m.
+func (r *ReconcileWordpress) finalizeWordpress(m *examplev1.Wordpress, reqLogger logr.Logger) (reconcile.Result, error) {
+	if !hasFinalizer(m, deletionFinalizer) {
+		return reconcile.Result{}, nil
+	}
+
+	switch m.Spec.DeletionPolicy {
+	case examplev1.DeletionPolicyRetain:
+		if err := r.retainData(m, reqLogger); err != nil {
+			return reconcile.Result{}, fmt.Errorf("retain: %w", err)
+		}
+	case examplev1.DeletionPolicyBackupThenDelete:
+		done, err := r.backupData(m, reqLogger)
+		if err != nil {
+			return reconcile.Result{}, fmt.Errorf("final backup: %w", err)
+		} else if !done {
+			reqLogger.Info("Waiting for the final backup to complete")
+			return reconcile.Result{RequeueAfter: rolloutPollInterval}, nil
+		}
+	}
+	// What a final backup held is deleted along with the rest of the site,
+	// also when the deletion policy was changed while the backup ran.
+	if m.Spec.DeletionPolicy != examplev1.DeletionPolicyRetain {
+		if err := r.deleteHeldForBackup(m, reqLogger); err != nil {
+			return reconcile.Result{}, fmt.Errorf("final backup: %w", err)
+		}
+	}
+
+	reqLogger.Info("Removing finalizer", "Finalizer", deletionFinalizer, "DeletionPolicy", m.Spec.DeletionPolicy)
+	controllerutil.RemoveFinalizer(m, deletionFinalizer)
+	return reconcile.Result{}, r.client.Update(context.TODO(), m)
+}
+
+// siteObject is an object of a site that finalization looks up by name.
+type siteObject struct {
+	kind  string
+	name  string
+	found client.Object
+}
+
+// dataObjects returns the objects holding the data of m: its Secret and the
+// PVCs of both tiers, including the claims of read replicas.
+func (r *ReconcileWordpress) dataObjects(m *examplev1.Wordpress) ([]siteObject, error) {
+	dbClaim, err := r.databaseClaimName(m)
+	if err != nil {
+		return nil, err
+	}
+	objs := []siteObject{
+		{"Secret", m.Name, &corev1.Secret{}},
+		{"PVC", dbClaim, &corev1.PersistentVolumeClaim{}},
+		{"PVC", fmt.Sprintf("%s-wordpress", m.Name), &corev1.PersistentVolumeClaim{}},
+	}
+	replicaClaims, err := r.replicaClaims(m)
+	if err != nil {
+		return nil, err
+	}
+	for _, pvc := range replicaClaims {
+		objs = append(objs, siteObject{"PVC", pvc.Name, &corev1.PersistentVolumeClaim{}})
+	}
+	return objs, nil
+}
+
+// backupObjects returns the objects the final backup of m needs: the data
+// objects and, for a managed database, the MySQL server and its Service.
+func (r *ReconcileWordpress) backupObjects(m *examplev1.Wordpress) ([]siteObject, error) {
+	objs, err := r.dataObjects(m)
+	if err != nil {
+		return nil, err
+	}
+	if m.Spec.Database.External == nil {
+		mysqlName := fmt.Sprintf("%s-mysql", m.Name)
+		objs = append(objs,
+			siteObject{"StatefulSet", mysqlName, &appsv1.StatefulSet{}},
+			siteObject{"Service", mysqlName, &corev1.Service{}},
+		)
+	}
+	return objs, nil
+}
+
+// retainData orphans the PVCs and the Secret of m, so that the garbage
+// collector leaves them alone, and labels them with the name of m. The claims
+// of read replicas are kept along with the primary's.
+func (r *ReconcileWordpress) retainData(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	objs, err := r.dataObjects(m)
+	if err != nil {
+		return err
+	}
+	return r.releaseFromSite(m, objs, retainedLabel, "Retaining", reqLogger)
+}
+
+// releaseFromSite removes the owner reference to m from each of objs that
+// exists and labels it with label set to the name of m. The label of an
+// earlier release is replaced. verb describes the release in logs.
+func (r *ReconcileWordpress) releaseFromSite(m *examplev1.Wordpress, objs []siteObject, label, verb string, reqLogger logr.Logger) error {
+	for _, obj := range objs {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: obj.name, Namespace: m.Namespace}, obj.found)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		o := obj.found.(metav1.Object)
+		var refs []metav1.OwnerReference
+		for _, ref := range o.GetOwnerReferences() {
+			if ref.UID != m.UID {
+				refs = append(refs, ref)
+			}
+		}
+		ls := o.GetLabels()
+		if ls == nil {
+			ls = map[string]string{}
+		}
+		if len(refs) == len(o.GetOwnerReferences()) && ls[label] == m.Name {
+			continue
+		}
+		o.SetOwnerReferences(refs)
+		delete(ls, retainedLabel)
+		delete(ls, backupHoldLabel)
+		ls[label] = m.Name
+		o.SetLabels(ls)
+
+		reqLogger.Info(verb+" "+obj.kind, obj.kind+".Name", obj.name)
+		if err := r.client.Update(context.TODO(), obj.found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteHeldForBackup deletes the objects a final backup of m held, and the
+// backup Job, which m does not own either.
+func (r *ReconcileWordpress) deleteHeldForBackup(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	objs, err := r.backupObjects(m)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: obj.name, Namespace: m.Namespace}, obj.found)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if obj.found.GetLabels()[backupHoldLabel] != m.Name {
+			continue
+		}
+		reqLogger.Info("Deleting "+obj.kind+" held for the final backup", obj.kind+".Name", obj.name)
+		err = r.client.Delete(context.TODO(), obj.found, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	job := &batchv1.Job{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: backupJobName(m), Namespace: m.Namespace}, job)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	reqLogger.Info("Deleting the final backup Job", "Job.Name", job.Name)
+	err = r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// adoptRetained removes the label retainData put on found, now that a
+// Wordpress owns it again. The owner reference itself is restored by the
+// apply that follows.
+func (r *ReconcileWordpress) adoptRetained(found client.Object, reqLogger logr.Logger) error {
+	o, ok := found.(metav1.Object)
+	if !ok {
+		return nil
+	}
+	if _, retained := o.GetLabels()[retainedLabel]; !retained {
+		return nil
+	}
+	ls := o.GetLabels()
+	delete(ls, retainedLabel)
+	o.SetLabels(ls)
+	reqLogger.Info("Adopting retained object", "Name", o.GetName())
+	return r.client.Update(context.TODO(), found)
+}
+
+// backupData takes the final backup of m. What the backup needs is released
+// from m first: a deletion in the foreground has the garbage collector delete
+// whatever m owns right away, without waiting for the finalizer. WordPress is
+// then stopped, so that its volume can be mounted by the backup Job and no
+// changes are lost. The claim the backup is written to is not owned by m and
+// outlives it. It reports whether the backup is complete.
+func (r *ReconcileWordpress) backupData(m *examplev1.Wordpress, reqLogger logr.Logger) (bool, error) {
+	objs, err := r.backupObjects(m)
+	if err != nil {
+		return false, err
+	}
+	if err := r.releaseFromSite(m, objs, backupHoldLabel, "Holding", reqLogger); err != nil {
+		return false, err
+	}
+
+	dep := &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-wordpress", m.Name), Namespace: m.Namespace}, dep)
+	if err == nil {
+		reqLogger.Info("Stopping wordpress for the final backup", "wordpressDep.Name", dep.Name)
+		err = r.client.Delete(context.TODO(), dep, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	pvc := backupPVCForWordpress(m)
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, &corev1.PersistentVolumeClaim{})
+	if err != nil && errors.IsNotFound(err) {
+		reqLogger.Info("Creating a new PVC for the final backup", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		err = r.client.Create(context.TODO(), pvc)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The finalizer stays until the backup succeeds. Changing the deletion
+	// policy lets the deletion go ahead without it.
+	done, err := r.runJob(r.backupJobForWordpress(m), "back up the site", reqLogger)
+	if !done || err != nil {
+		return false, err
+	}
+	reqLogger.Info("Completed the final backup", "PVC.Name", pvc.Name)
+	return true, nil
+}
+
+// backupPVCForWordpress returns the claim the final backup of m is written
+// to. It is large enough to hold a copy of both volumes.
+func backupPVCForWordpress(m *examplev1.Wordpress) *corev1.PersistentVolumeClaim {
+	ls := labelsForWordpress(m.Name)
+	ls[backupLabel] = m.Name
+
+	spec := pvcSpecForVolume(m.Spec.Storage.Database)
+	size := spec.Resources.Requests[corev1.ResourceStorage]
+	wpSize := pvcSpecForVolume(m.Spec.Storage.Wordpress).Resources.Requests[corev1.ResourceStorage]
+	size.Add(wpSize)
+	spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: size}
+	spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-backup", m.Name),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: spec,
+	}
+}
+
+func (r *ReconcileWordpress) backupJobForWordpress(m *examplev1.Wordpress) *batchv1.Job {
+	job := r.newJob(m, backupJobName(m), jobLabelsForWordpress(m.Name, "final-backup"), 3600, corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Image:   databaseClientImage(m),
+			Name:    "backup",
+			Command: []string{"sh", "-c", databaseScript(m, finalBackupScript)},
+			Env:     backupDatabaseEnv(m),
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "backup",
+					MountPath: "/backup",
+				},
+				{
+					Name:      "wordpress-persistent-storage",
+					MountPath: "/var/www/html",
+					ReadOnly:  true,
+				},
+			},
+		}},
+		Volumes: []corev1.Volume{
+			{
+				Name: "backup",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: fmt.Sprintf("%s-backup", m.Name),
+					},
+				},
+			},
+			{
+				Name: "wordpress-persistent-storage",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: fmt.Sprintf("%s-wordpress", m.Name),
+					},
+				},
+			},
+		},
+		// The Job mounts the WordPress volume, which may only be reachable
+		// from the nodes the WordPress pods are pinned to.
+		NodeSelector: m.Spec.Wordpress.NodeSelector,
+		Tolerations:  m.Spec.Wordpress.Tolerations,
+	})
+	// The WordPress files are readable by the group that owns them.
+	if sc := job.Spec.Template.Spec.SecurityContext; sc != nil {
+		group := wordpressUser
+		sc.FSGroup = &group
+	}
+	// The Job runs while m is being deleted; were it owned by m, the garbage
+	// collector could delete it before it is done.
+	job.OwnerReferences = nil
+
+	return job
+}
+
+// backupDatabaseEnv returns the environment finalBackupScript connects to the
+// database of m with. The managed MySQL server is dumped as a whole with the
+// root user; of an external server only the database of the site is dumped.
+func backupDatabaseEnv(m *examplev1.Wordpress) []corev1.EnvVar {
+	if ext := m.Spec.Database.External; ext != nil {
+		return []corev1.EnvVar{
+			{Name: "DB_HOST", Value: ext.Host},
+			{Name: "DB_PORT", Value: externalDatabasePort(ext)},
+			{Name: "DB_USER", Value: ext.User},
+			{
+				Name: "MYSQL_PWD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: ext.CredentialsSecretRef.DeepCopy(),
+				},
+			},
+			{Name: "DUMP_ARGS", Value: "--databases " + externalDatabaseName(ext)},
+		}
+	}
+	return []corev1.EnvVar{
+		{Name: "DB_HOST", Value: fmt.Sprintf("%s-mysql", m.Name)},
+		{Name: "DB_PORT", Value: "3306"},
+		{Name: "DB_USER", Value: "root"},
+		secretEnvVar("MYSQL_PWD", m.Name, secretRootPasswordKey),
+		{Name: "DUMP_ARGS", Value: "--all-databases --routines --events"},
+	}
+}
+
+func backupJobName(m *examplev1.Wordpress) string {
+	return fmt.Sprintf("%s-final-backup", m.Name)
+}
+
+func hasFinalizer(o metav1.Object, finalizer string) bool {
+	for _, f := range o.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
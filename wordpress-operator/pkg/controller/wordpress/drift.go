@@ -0,0 +1,238 @@
+package wordpress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// fieldManager owns the fields the operator sets through server-side apply.
+const fieldManager = "wordpress-operator"
+
+// apply creates or updates obj through a server-side apply, which makes the
+// operator the owner of every field set in obj. Fields the operator owns but
+// leaves out of a later apply are removed. obj is updated with the result.
+func (r *ReconcileWordpress) apply(obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, r.scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return r.client.Patch(context.TODO(), obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// applyChanges brings found back in line with desired through a server-side
+// apply. The operator takes ownership of every field set in desired, so
+// changes others made to those fields are overwritten, and fields it set
+// before but desired leaves unset are removed. Fields only others set are
+// left alone. desired is updated with the result. It reports whether the
+// object was changed.
+func (r *ReconcileWordpress) applyChanges(found, desired client.Object) (bool, error) {
+	if err := r.upgradeManagedFields(found); err != nil {
+		return false, err
+	}
+	if err := r.apply(desired); err != nil {
+		return false, err
+	}
+	return desired.GetResourceVersion() != found.GetResourceVersion(), nil
+}
+
+// upgradeManagedFields hands the fields the operator set through updates over
+// to its apply. Children used to be created with a plain create, and the
+// fields an update sets stay owned by it, so without this fields the operator
+// stops asking for would never be removed.
+func (r *ReconcileWordpress) upgradeManagedFields(found client.Object) error {
+	entries, upgraded, err := mergeUpdateManagedFields(found.GetManagedFields())
+	if err != nil || !upgraded {
+		return err
+	}
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": found.GetResourceVersion()},
+		{"op": "replace", "path": "/metadata/managedFields", "value": entries},
+	})
+	if err != nil {
+		return err
+	}
+	return r.client.Patch(context.TODO(), found, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// mergeUpdateManagedFields returns entries with the fields owned by the
+// operator's updates added to those owned by its apply. It reports whether
+// there was anything to merge.
+func mergeUpdateManagedFields(entries []metav1.ManagedFieldsEntry) ([]metav1.ManagedFieldsEntry, bool, error) {
+	var apply *metav1.ManagedFieldsEntry
+	var updates []metav1.ManagedFieldsEntry
+	var kept []metav1.ManagedFieldsEntry
+	for _, e := range entries {
+		switch {
+		case e.Manager == fieldManager && e.Operation == metav1.ManagedFieldsOperationApply:
+			e := e
+			apply = &e
+		case e.Manager == fieldManager && e.Operation == metav1.ManagedFieldsOperationUpdate:
+			updates = append(updates, e)
+		default:
+			kept = append(kept, e)
+		}
+	}
+	if len(updates) == 0 {
+		return entries, false, nil
+	}
+	if apply == nil {
+		apply = &metav1.ManagedFieldsEntry{
+			Manager:    fieldManager,
+			Operation:  metav1.ManagedFieldsOperationApply,
+			APIVersion: updates[0].APIVersion,
+			Time:       updates[0].Time,
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte("{}")},
+		}
+	}
+
+	owned, err := fieldSet(apply.FieldsV1)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, u := range updates {
+		set, err := fieldSet(u.FieldsV1)
+		if err != nil {
+			return nil, false, err
+		}
+		owned = owned.Union(set)
+	}
+	raw, err := owned.ToJSON()
+	if err != nil {
+		return nil, false, err
+	}
+	apply.FieldsV1 = &metav1.FieldsV1{Raw: raw}
+	return append(kept, *apply), true, nil
+}
+
+func fieldSet(f *metav1.FieldsV1) (*fieldpath.Set, error) {
+	set := &fieldpath.Set{}
+	if f == nil {
+		return set, nil
+	}
+	return set, set.FromJSON(bytes.NewReader(f.Raw))
+}
+
+// applyDeployment corrects drift on an existing Deployment.
+func (r *ReconcileWordpress) applyDeployment(found, desired *appsv1.Deployment) (bool, error) {
+	// The rollingUpdate parameters the API server defaulted are not owned by the
+	// operator, and must be cleared in the same write that switches to Recreate.
+	if found.Spec.Strategy.Type != desired.Spec.Strategy.Type && desired.Spec.Strategy.Type != "" {
+		found.Spec.Strategy = desired.Spec.Strategy
+		err := r.client.Update(context.TODO(), found)
+		if err != nil {
+			return false, err
+		}
+	}
+	return r.applyChanges(found, desired)
+}
+
+// applyService corrects drift on an existing Service. The API server assigns
+// node ports to NodePort and LoadBalancer Services, and refuses a ClusterIP
+// Service that still has them, so they are cleared in the same write that
+// switches to ClusterIP.
+func (r *ReconcileWordpress) applyService(found, desired *corev1.Service) (bool, error) {
+	if found.Spec.Type != desired.Spec.Type && desired.Spec.Type == corev1.ServiceTypeClusterIP {
+		found.Spec.Type = desired.Spec.Type
+		for i := range found.Spec.Ports {
+			found.Spec.Ports[i].NodePort = 0
+		}
+		found.Spec.ExternalTrafficPolicy = ""
+		found.Spec.HealthCheckNodePort = 0
+		err := r.client.Update(context.TODO(), found)
+		if err != nil {
+			return false, err
+		}
+	}
+	return r.applyChanges(found, desired)
+}
+
+// applyStatefulSet corrects drift on an existing StatefulSet. Its
+// volumeClaimTemplates cannot change after creation, so the ones it has are
+// applied again; ensureStorage resizes the claims themselves.
+func (r *ReconcileWordpress) applyStatefulSet(found, desired *appsv1.StatefulSet) (bool, error) {
+	desired.Spec.VolumeClaimTemplates = found.Spec.VolumeClaimTemplates
+	return r.applyChanges(found, desired)
+}
+
+// applyPVC corrects drift on an existing claim. Only the labels, the owner and
+// the requested size can change; the rest of a claim's spec cannot change
+// after creation, so it is applied as found. Kubernetes cannot shrink a claim,
+// and the API server refuses to expand one whose StorageClass does not allow
+// expansion; both cases are returned as a resizeRejectedError.
+func (r *ReconcileWordpress) applyPVC(found, desired *corev1.PersistentVolumeClaim) (bool, error) {
+	current := found.Spec.Resources.Requests[corev1.ResourceStorage]
+	requested := desired.Spec.Resources.Requests[corev1.ResourceStorage]
+
+	var rejected error
+	if requested.Cmp(current) < 0 {
+		rejected = &resizeRejectedError{fmt.Sprintf("PersistentVolumeClaim %s cannot shrink from %s to %s", found.Name, current.String(), requested.String())}
+		requested = current
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            desired.Name,
+			Namespace:       desired.Namespace,
+			Labels:          desired.Labels,
+			OwnerReferences: desired.OwnerReferences,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      found.Spec.AccessModes,
+			StorageClassName: found.Spec.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: requested,
+				},
+			},
+		},
+	}
+	changed, err := r.applyChanges(found, pvc)
+	if errors.IsForbidden(err) || errors.IsInvalid(err) {
+		return false, &resizeRejectedError{fmt.Sprintf("PersistentVolumeClaim %s cannot expand to %s: %v", found.Name, requested.String(), err)}
+	} else if err != nil {
+		return false, err
+	}
+	return changed, rejected
+}
+
+// resizeRejectedError is returned when a claim cannot be resized as requested.
+type resizeRejectedError struct {
+	msg string
+}
+
+func (e *resizeRejectedError) Error() string {
+	return e.msg
+}
+
+// immutablePVCConflicts describes the fields of found that differ from what v
+// asks for but cannot be changed on an existing claim.
+func immutablePVCConflicts(found *corev1.PersistentVolumeClaim, v examplev1.VolumeSpec) []string {
+	var conflicts []string
+	if v.StorageClassName != nil && (found.Spec.StorageClassName == nil || *found.Spec.StorageClassName != *v.StorageClassName) {
+		current := ""
+		if found.Spec.StorageClassName != nil {
+			current = *found.Spec.StorageClassName
+		}
+		conflicts = append(conflicts, fmt.Sprintf("PersistentVolumeClaim %s has storageClassName %q, not %q", found.Name, current, *v.StorageClassName))
+	}
+	if len(v.AccessModes) > 0 && !reflect.DeepEqual(found.Spec.AccessModes, v.AccessModes) {
+		conflicts = append(conflicts, fmt.Sprintf("PersistentVolumeClaim %s has accessModes %v, not %v", found.Name, found.Spec.AccessModes, v.AccessModes))
+	}
+	return conflicts
+}
@@ -0,0 +1,236 @@
+package wordpress
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestEnsureDatabaseDropsFieldsNoLongerAsked(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			SecurityProfile: examplev1.SecurityProfileRestricted,
+			Database: examplev1.DatabaseSpec{
+				Replicas:       1,
+				SchedulingSpec: examplev1.SchedulingSpec{NodeSelector: map[string]string{"disktype": "ssd"}},
+			},
+		},
+	}
+	r := newTestReconciler(t, wp)
+	reqLogger := log.WithValues("Request.Name", "mysite")
+
+	if _, err := r.ensureDatabase(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+
+	wp.Spec.SecurityProfile = examplev1.SecurityProfileDefault
+	wp.Spec.Database.Replicas = 0
+	wp.Spec.Database.NodeSelector = nil
+	if _, err := r.ensureDatabase(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+
+	sts := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, sts); err != nil {
+		t.Fatal(err)
+	}
+	pod := sts.Spec.Template.Spec
+	if pod.SecurityContext != nil || pod.Containers[0].SecurityContext != nil {
+		t.Errorf("security contexts = %+v, %+v; want none for the Default profile", pod.SecurityContext, pod.Containers[0].SecurityContext)
+	}
+	if len(pod.NodeSelector) != 0 {
+		t.Errorf("node selector = %v, want none", pod.NodeSelector)
+	}
+	if pod.Containers[0].Command != nil {
+		t.Errorf("command = %v, want the image's without replicas", pod.Containers[0].Command)
+	}
+	if len(sts.Spec.VolumeClaimTemplates) != 1 {
+		t.Errorf("volumeClaimTemplates = %+v, want them kept as created", sts.Spec.VolumeClaimTemplates)
+	}
+}
+
+func TestMergeUpdateManagedFields(t *testing.T) {
+	fields := func(paths ...fieldpath.Path) *metav1.FieldsV1 {
+		raw, err := fieldpath.NewSet(paths...).ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &metav1.FieldsV1{Raw: raw}
+	}
+	replicas := fieldpath.MakePathOrDie("spec", "replicas")
+	nodeSelector := fieldpath.MakePathOrDie("spec", "template", "spec", "nodeSelector")
+	status := fieldpath.MakePathOrDie("status", "readyReplicas")
+
+	entries := []metav1.ManagedFieldsEntry{
+		{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationUpdate, FieldsType: "FieldsV1", FieldsV1: fields(nodeSelector)},
+		{Manager: fieldManager, Operation: metav1.ManagedFieldsOperationApply, FieldsType: "FieldsV1", FieldsV1: fields(replicas)},
+		{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate, FieldsType: "FieldsV1", FieldsV1: fields(status)},
+	}
+	merged, upgraded, err := mergeUpdateManagedFields(entries)
+	if err != nil || !upgraded {
+		t.Fatalf("mergeUpdateManagedFields = %v, %v; want the update merged", upgraded, err)
+	}
+	if len(merged) != 2 || merged[0].Manager != "kube-controller-manager" {
+		t.Fatalf("entries = %+v, want the controller's and a single apply", merged)
+	}
+	owned, err := fieldSet(merged[1].FieldsV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged[1].Operation != metav1.ManagedFieldsOperationApply || !owned.Has(replicas) || !owned.Has(nodeSelector) || owned.Has(status) {
+		t.Errorf("apply entry = %+v owning %s, want replicas and nodeSelector", merged[1], owned)
+	}
+
+	if _, upgraded, _ := mergeUpdateManagedFields(merged); upgraded {
+		t.Error("merged entries were upgraded again")
+	}
+}
+
+func TestApplyPVCRejectsShrink(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	found := r.wordpressPVCForWordpress(wp)
+	found.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("30Gi")
+	if err := r.client.Create(context.TODO(), found); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := r.applyPVC(found, r.wordpressPVCForWordpress(wp))
+	if _, ok := err.(*resizeRejectedError); !ok {
+		t.Fatalf("applyPVC = %v, want the shrink to 20Gi rejected", err)
+	}
+	got := &corev1.PersistentVolumeClaim{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if size := got.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "30Gi" {
+		t.Errorf("claim requests %s, want it kept at 30Gi", size.String())
+	}
+}
+
+func TestEnsureStorageExpandsPrimaryClaim(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	reqLogger := log.WithValues("Request.Name", "mysite")
+	if err := r.ensureStorage(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+
+	size := resource.MustParse("40Gi")
+	wp.Spec.Storage.Database.Size = &size
+	if err := r.ensureStorage(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+	got := &corev1.PersistentVolumeClaim{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "data-mysite-mysql-0", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if requested := got.Spec.Resources.Requests[corev1.ResourceStorage]; requested.Cmp(size) != 0 {
+		t.Errorf("primary claim requests %s, want 40Gi", requested.String())
+	}
+	if meta.IsStatusConditionTrue(wp.Status.Conditions, examplev1.ConditionStorageResizeFailed) {
+		t.Error("the expansion is reported as failed")
+	}
+
+	// Shrinking it back is reported, not applied.
+	smaller := resource.MustParse("10Gi")
+	wp.Spec.Storage.Database.Size = &smaller
+	if err := r.ensureStorage(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(wp.Status.Conditions, examplev1.ConditionStorageResizeFailed) {
+		t.Errorf("conditions = %+v, want the shrink reported", wp.Status.Conditions)
+	}
+}
+
+func TestReconcileRestoresEditedChildren(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	key := types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}
+
+	// Someone drops an env var from WordPress and exposes it another way.
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), key, dep); err != nil {
+		t.Fatal(err)
+	}
+	want := dep.Spec.Template.Spec.Containers[0].Env
+	dep.Spec.Template.Spec.Containers[0].Env = want[1:]
+	if err := r.client.Update(context.TODO(), dep); err != nil {
+		t.Fatal(err)
+	}
+	svc := &corev1.Service{}
+	if err := r.client.Get(context.TODO(), key, svc); err != nil {
+		t.Fatal(err)
+	}
+	svc.Spec.Type = corev1.ServiceTypeNodePort
+	if err := r.client.Update(context.TODO(), svc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	dep = &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if got := dep.Spec.Template.Spec.Containers[0].Env; len(got) != len(want) || got[0].Name != want[0].Name {
+		t.Errorf("env = %+v, want %s restored", got, want[0].Name)
+	}
+	svc = &corev1.Service{}
+	if err := r.client.Get(context.TODO(), key, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		t.Errorf("Service type = %s, want LoadBalancer restored", svc.Spec.Type)
+	}
+}
+
+func TestEnsureStorageReportsImmutableFields(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	reqLogger := log.WithValues("Request.Name", "mysite")
+	if err := r.ensureStorage(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+
+	class := "fast"
+	wp.Spec.Storage.Wordpress.StorageClassName = &class
+	if err := r.ensureStorage(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(wp.Status.Conditions, examplev1.ConditionImmutableFieldConflict) {
+		t.Errorf("conditions = %+v, want the StorageClass change reported", wp.Status.Conditions)
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, pvc); err != nil {
+		t.Fatal(err)
+	}
+	if pvc.Spec.StorageClassName != nil {
+		t.Errorf("StorageClass = %s, want the claim left as created", *pvc.Spec.StorageClassName)
+	}
+}
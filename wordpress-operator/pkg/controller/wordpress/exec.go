@@ -0,0 +1,64 @@
+package wordpress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// podExecutor runs commands in the containers of running pods.
+type podExecutor interface {
+	// Exec runs command in the named container, feeding it stdin if not nil,
+	// and returns what it wrote to its standard output.
+	Exec(namespace, pod, container string, command []string, stdin io.Reader) (string, error)
+}
+
+// remoteExecutor runs commands through the exec subresource of pods.
+type remoteExecutor struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+}
+
+func newRemoteExecutor(config *rest.Config) (*remoteExecutor, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteExecutor{config: config, clientset: clientset}, nil
+}
+
+func (e *remoteExecutor) Exec(namespace, pod, container string, command []string, stdin io.Reader) (string, error) {
+	req := e.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	err = exec.Stream(remotecommand.StreamOptions{Stdin: stdin, Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,177 @@
+package wordpress
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+const (
+	defaultExternalDatabasePort = 3306
+	// defaultDatabaseClientImage is used to reach an external database. Its
+	// client speaks every authentication method of MySQL 5.6 and later.
+	defaultDatabaseClientImage = "mysql:8.4"
+)
+
+// checkDatabaseScript logs in to the database $DB_NAME on $DB_HOST:$DB_PORT as
+// $DB_USER, with the password in $MYSQL_PWD.
+const checkDatabaseScript = `set -e
+mysql -h "$DB_HOST" -P "$DB_PORT" -u"$DB_USER" --connect-timeout=10 -e 'SELECT 1' "$DB_NAME" >/dev/null
+echo "connected to $DB_NAME on $DB_HOST:$DB_PORT"
+`
+
+// verifyExternalDatabase checks that WordPress can log in to the external
+// database of m with the configured credentials. The check runs as a Job in
+// the namespace of the site, so it sees the network the way WordPress does.
+// It is named after the connection settings and the version of the Secret
+// holding the password, so it runs again whenever either changes. A failed
+// check is deleted, so that it is retried on the next reconcile. It reports
+// whether the check succeeded.
+func (r *ReconcileWordpress) verifyExternalDatabase(m *examplev1.Wordpress, reqLogger logr.Logger) (bool, error) {
+	creds, err := r.externalCredentialsForWordpress(m)
+	if err != nil {
+		return false, err
+	}
+	job := r.databaseCheckJobForWordpress(m, creds)
+
+	// Checks of earlier settings no longer tell anything.
+	jobs := &batchv1.JobList{}
+	err = r.client.List(context.TODO(), jobs, client.InNamespace(m.Namespace), client.MatchingLabels(databaseCheckLabels(m.Name)))
+	if err != nil {
+		return false, err
+	}
+	for i := range jobs.Items {
+		if jobs.Items[i].Name == job.Name || jobs.Items[i].DeletionTimestamp != nil {
+			continue
+		}
+		reqLogger.Info("Deleting outdated database check", "Job.Name", jobs.Items[i].Name)
+		err = r.client.Delete(context.TODO(), &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+
+	done, err := r.runJob(job, "connect to the external database", reqLogger)
+	if err != nil {
+		delErr := r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if delErr != nil && !errors.IsNotFound(delErr) {
+			return false, delErr
+		}
+	}
+	return done, err
+}
+
+// externalCredentialsForWordpress returns the Secret holding the password of
+// the external database user of m.
+func (r *ReconcileWordpress) externalCredentialsForWordpress(m *examplev1.Wordpress) (*corev1.Secret, error) {
+	ref := m.Spec.Database.External.CredentialsSecretRef
+	sec := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: m.Namespace}, sec)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentialsSecretRef: %w", err)
+	}
+	if _, ok := sec.Data[ref.Key]; !ok {
+		return nil, fmt.Errorf("credentialsSecretRef: Secret %s has no key %q", ref.Name, ref.Key)
+	}
+	return sec, nil
+}
+
+// databaseCheckJobForWordpress returns the Job that checks the connection of m
+// to its external database with the password in creds. Nothing derived from
+// the password ends up in its name; the Secret is told apart by its UID and
+// resource version instead.
+func (r *ReconcileWordpress) databaseCheckJobForWordpress(m *examplev1.Wordpress, creds *corev1.Secret) *batchv1.Job {
+	ext := m.Spec.Database.External
+
+	h := sha256.New()
+	for _, v := range []string{ext.Host, externalDatabasePort(ext), externalDatabaseName(ext), ext.User, string(creds.UID), creds.ResourceVersion} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	digest := hex.EncodeToString(h.Sum(nil))[:10]
+
+	job := r.newJob(m, fmt.Sprintf("%s-db-check-%s", m.Name, digest), databaseCheckLabels(m.Name), 120, corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Image:   databaseClientImage(m),
+			Name:    "check",
+			Command: []string{"sh", "-c", checkDatabaseScript},
+			Env: []corev1.EnvVar{
+				{Name: "DB_HOST", Value: ext.Host},
+				{Name: "DB_PORT", Value: externalDatabasePort(ext)},
+				{Name: "DB_NAME", Value: externalDatabaseName(ext)},
+				{Name: "DB_USER", Value: ext.User},
+				{
+					Name: "MYSQL_PWD",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: ext.CredentialsSecretRef.DeepCopy(),
+					},
+				},
+			},
+		}},
+	})
+	// A failed check is retried by the next reconcile, not by the Job.
+	backoffLimit := int32(2)
+	job.Spec.BackoffLimit = &backoffLimit
+
+	return job
+}
+
+// externalDatabaseReady reports whether the database checks in jobs show that
+// the external database is reachable. Checks being deleted are outdated.
+func externalDatabaseReady(jobs []batchv1.Job) bool {
+	ready := false
+	for i := range jobs {
+		if jobs[i].DeletionTimestamp != nil {
+			continue
+		}
+		if !jobHasCondition(&jobs[i], batchv1.JobComplete) {
+			return false
+		}
+		ready = true
+	}
+	return ready
+}
+
+func databaseCheckLabels(name string) map[string]string {
+	return jobLabelsForWordpress(name, "database-check")
+}
+
+// externalDatabaseAddress returns the host:port WordPress connects to.
+func externalDatabaseAddress(ext *examplev1.ExternalDatabaseSpec) string {
+	return net.JoinHostPort(ext.Host, externalDatabasePort(ext))
+}
+
+func externalDatabasePort(ext *examplev1.ExternalDatabaseSpec) string {
+	if ext.Port == 0 {
+		return strconv.Itoa(defaultExternalDatabasePort)
+	}
+	return strconv.Itoa(int(ext.Port))
+}
+
+func externalDatabaseName(ext *examplev1.ExternalDatabaseSpec) string {
+	if ext.DatabaseName == "" {
+		return defaultDatabaseName
+	}
+	return ext.DatabaseName
+}
+
+// databaseClientImage returns the image Jobs use to connect to the database of m.
+func databaseClientImage(m *examplev1.Wordpress) string {
+	if m.Spec.Database.External != nil && m.Spec.Database.Image == "" {
+		return defaultDatabaseClientImage
+	}
+	return mysqlImage(m)
+}
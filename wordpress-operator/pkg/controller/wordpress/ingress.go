@@ -0,0 +1,99 @@
+package wordpress
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// ensureIngress creates the Ingress of m, or corrects drift on it. The
+// Ingress is deleted when spec.ingress is removed. The certificate the
+// Ingress is served with is requested from cert-manager first, when the spec
+// asks for one.
+func (r *ReconcileWordpress) ensureIngress(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	if err := r.ensureCertificate(m, reqLogger); err != nil {
+		return err
+	}
+	if m.Spec.Ingress == nil {
+		return r.deleteIfExists(m, fmt.Sprintf("%s-wordpress", m.Name), &networkingv1.Ingress{}, reqLogger)
+	}
+
+	ing := r.wordpressIngressForWordpress(m)
+	found := &networkingv1.Ingress{}
+	_, err := r.ensure(ing, found, func() (bool, error) {
+		return r.applyChanges(found, ing)
+	}, reqLogger)
+	return err
+}
+
+// wordpressIngressForWordpress returns the Ingress routing the hostnames of m
+// to its WordPress Service.
+func (r *ReconcileWordpress) wordpressIngressForWordpress(m *examplev1.Wordpress) *networkingv1.Ingress {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "frontend"
+
+	spec := m.Spec.Ingress
+	pathType := networkingv1.PathTypePrefix
+	backend := networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: fmt.Sprintf("%s-wordpress", m.Name),
+			Port: networkingv1.ServiceBackendPort{Number: 80},
+		},
+	}
+
+	var rules []networkingv1.IngressRule
+	for _, host := range spec.Hosts {
+		rules = append(rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     "/",
+						PathType: &pathType,
+						Backend:  backend,
+					}},
+				},
+			},
+		})
+	}
+
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-wordpress", m.Name),
+			Namespace:   m.Namespace,
+			Labels:      ls,
+			Annotations: spec.Annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: spec.IngressClassName,
+			Rules:            rules,
+		},
+	}
+	if secret := tlsSecretName(m); secret != "" {
+		ing.Spec.TLS = []networkingv1.IngressTLS{{
+			Hosts:      spec.Hosts,
+			SecretName: secret,
+		}}
+	}
+
+	controllerutil.SetControllerReference(m, ing, r.scheme)
+
+	return ing
+}
+
+// ingressURL returns the address the site of m is served at through its Ingress.
+func ingressURL(m *examplev1.Wordpress) string {
+	if len(m.Spec.Ingress.Hosts) == 0 {
+		return ""
+	}
+	scheme := "http"
+	if tlsSecretName(m) != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + m.Spec.Ingress.Hosts[0]
+}
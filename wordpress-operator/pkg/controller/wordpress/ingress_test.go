@@ -0,0 +1,91 @@
+package wordpress
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestReconcileServesSiteThroughIngress(t *testing.T) {
+	class := "nginx"
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Wordpress: examplev1.WordpressTierSpec{ServiceType: corev1.ServiceTypeClusterIP},
+			Ingress: &examplev1.IngressSpec{
+				IngressClassName: &class,
+				Hosts:            []string{"blog.example.com", "www.blog.example.com"},
+				Annotations:      map[string]string{"nginx.ingress.kubernetes.io/proxy-body-size": "64m"},
+				TLS:              &examplev1.IngressTLSSpec{SecretName: "blog-tls"},
+			},
+		},
+	}
+	r := newTestReconciler(t, wp)
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	svc := &corev1.Service{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeClusterIP {
+		t.Errorf("Service type = %s, want ClusterIP", svc.Spec.Type)
+	}
+
+	ing := &networkingv1.Ingress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, ing); err != nil {
+		t.Fatalf("Ingress was not created: %v", err)
+	}
+	if len(ing.Spec.Rules) != 2 || ing.Spec.Rules[1].Host != "www.blog.example.com" {
+		t.Errorf("rules = %+v, want one per host", ing.Spec.Rules)
+	}
+	if ing.Spec.IngressClassName == nil || *ing.Spec.IngressClassName != "nginx" {
+		t.Errorf("ingress class = %v, want nginx", ing.Spec.IngressClassName)
+	}
+	path := ing.Spec.Rules[0].HTTP.Paths[0]
+	if path.PathType == nil || *path.PathType != networkingv1.PathTypePrefix {
+		t.Errorf("path type = %v, want Prefix", path.PathType)
+	}
+	if svc := path.Backend.Service; svc == nil || svc.Name != "mysite-wordpress" || svc.Port.Number != 80 {
+		t.Errorf("backend = %+v, want the WordPress Service", path.Backend)
+	}
+	if len(ing.Spec.TLS) != 1 || ing.Spec.TLS[0].SecretName != "blog-tls" {
+		t.Errorf("tls = %+v, want the blog-tls certificate", ing.Spec.TLS)
+	}
+	if ing.Annotations["nginx.ingress.kubernetes.io/proxy-body-size"] != "64m" {
+		t.Errorf("annotations = %v", ing.Annotations)
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.URL != "https://blog.example.com" {
+		t.Errorf("URL = %q, want the first host over https", got.Status.URL)
+	}
+
+	// The endpoints are those of the Ingress, not of the Service.
+	ing.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.8"}}
+	if err := r.client.Status().Update(context.TODO(), ing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	got = &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.Endpoints) != 1 || got.Status.Endpoints[0] != "203.0.113.8" {
+		t.Errorf("endpoints = %v, want the address of the Ingress", got.Status.Endpoints)
+	}
+}
@@ -0,0 +1,118 @@
+package wordpress
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// newJob returns the Job named name of m, labelled ls, that runs podSpec to
+// completion within deadline seconds. Its containers get the resources of a
+// Job and its pods run as the database user under the security profile of
+// m. The Job is owned by m.
+func (r *ReconcileWordpress) newJob(m *examplev1.Wordpress, name string, ls map[string]string, deadline int64, podSpec corev1.PodSpec) *batchv1.Job {
+	backoffLimit := int32(6)
+
+	podSpec.RestartPolicy = corev1.RestartPolicyOnFailure
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].Resources = jobResources()
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+
+	hardenJobPod(m, &job.Spec.Template, databaseUser)
+
+	controllerutil.SetControllerReference(m, job, r.scheme)
+
+	return job
+}
+
+// runJob creates desired unless a Job of that name exists, and reports whether
+// the Job has completed. purpose describes what the Job does, for logs and
+// errors. A failed Job is returned as an error; it is not retried until it is
+// deleted.
+func (r *ReconcileWordpress) runJob(desired *batchv1.Job, purpose string, reqLogger logr.Logger) (bool, error) {
+	job := &batchv1.Job{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		reqLogger.Info("Creating a new Job to "+purpose, "Job.Namespace", desired.Namespace, "Job.Name", desired.Name)
+		return false, r.client.Create(context.TODO(), desired)
+	} else if err != nil {
+		return false, err
+	}
+
+	if jobHasCondition(job, batchv1.JobFailed) {
+		return false, &jobFailedError{fmt.Sprintf("job %s failed to %s", job.Name, purpose)}
+	}
+	return jobHasCondition(job, batchv1.JobComplete), nil
+}
+
+// jobFailedError is returned by runJob for a Job that failed.
+type jobFailedError struct {
+	msg string
+}
+
+func (e *jobFailedError) Error() string {
+	return e.msg
+}
+
+// jobFailed reports whether err, or any error wrapped or aggregated in it, is
+// a failed Job.
+func jobFailed(err error) bool {
+	var agg utilerrors.Aggregate
+	if goerrors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if jobFailed(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var failed *jobFailedError
+	return goerrors.As(err, &failed)
+}
+
+// jobLabelsForWordpress returns the labels of a Job of the site named name.
+// They leave out the tier, so that the pods of a Job are never selected by the
+// Deployments and Services of the site.
+func jobLabelsForWordpress(name, job string) map[string]string {
+	ls := labelsForWordpress(name)
+	ls["job"] = job
+	return ls
+}
+
+func jobHasCondition(job *batchv1.Job, t batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == t && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package wordpress
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// disableNetworkPoliciesEnvVar turns off the NetworkPolicies of every site,
+// for clusters whose network plugin does not enforce them.
+const disableNetworkPoliciesEnvVar = "DISABLE_NETWORK_POLICIES"
+
+// ingressNamespaceEnvVar names the namespace of the ingress controller, which
+// may reach WordPress unless spec.networkPolicy.wordpressFrom says otherwise.
+const ingressNamespaceEnvVar = "INGRESS_CONTROLLER_NAMESPACE"
+
+const defaultIngressNamespace = "ingress-nginx"
+
+// namespaceNameLabel is set on every namespace by Kubernetes 1.21 and later.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// operatorNetworkPoliciesDisabled reports whether the operator is configured
+// to leave out NetworkPolicies.
+func operatorNetworkPoliciesDisabled() (bool, error) {
+	value := os.Getenv(disableNetworkPoliciesEnvVar)
+	if value == "" {
+		return false, nil
+	}
+	disabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", disableNetworkPoliciesEnvVar, err)
+	}
+	return disabled, nil
+}
+
+// operatorIngressNamespace returns the namespace of the ingress controller.
+func operatorIngressNamespace() string {
+	if ns := os.Getenv(ingressNamespaceEnvVar); ns != "" {
+		return ns
+	}
+	return defaultIngressNamespace
+}
+
+// ensureNetworkPolicies creates the NetworkPolicies of m, or corrects drift
+// on them. The database policy is deleted when the site uses an external
+// database, and both when the operator leaves out NetworkPolicies.
+func (r *ReconcileWordpress) ensureNetworkPolicies(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	mysqlName := fmt.Sprintf("%s-mysql", m.Name)
+	if r.networkPoliciesDisabled || m.Spec.Database.External != nil {
+		if err := r.deleteIfExists(m, mysqlName, &networkingv1.NetworkPolicy{}, reqLogger); err != nil {
+			return err
+		}
+	} else {
+		np := r.mysqlNetworkPolicyForWordpress(m)
+		found := &networkingv1.NetworkPolicy{}
+		_, err := r.ensure(np, found, func() (bool, error) {
+			return r.applyChanges(found, np)
+		}, reqLogger)
+		if err != nil {
+			return err
+		}
+	}
+
+	wordpressName := fmt.Sprintf("%s-wordpress", m.Name)
+	if r.networkPoliciesDisabled {
+		return r.deleteIfExists(m, wordpressName, &networkingv1.NetworkPolicy{}, reqLogger)
+	}
+	np := r.wordpressNetworkPolicyForWordpress(m)
+	found := &networkingv1.NetworkPolicy{}
+	_, err := r.ensure(np, found, func() (bool, error) {
+		return r.applyChanges(found, np)
+	}, reqLogger)
+	return err
+}
+
+// mysqlNetworkPolicyForWordpress returns the NetworkPolicy that only lets the
+// pods of m reach its database: the WordPress pods, the pods of its Jobs, and
+// the other database pods, which replicate from the primary.
+func (r *ReconcileWordpress) mysqlNetworkPolicyForWordpress(m *examplev1.Wordpress) *networkingv1.NetworkPolicy {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "mysql"
+	frontend := labelsForWordpress(m.Name)
+	frontend["tier"] = "frontend"
+
+	port := intstr.FromString("mysql")
+	protocol := corev1.ProtocolTCP
+
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-mysql", m.Name),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: ls},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{MatchLabels: frontend}},
+					{PodSelector: &metav1.LabelSelector{MatchLabels: ls}},
+					// Job pods carry the labels of the site and a job label,
+					// but no tier.
+					{PodSelector: &metav1.LabelSelector{
+						MatchLabels: labelsForWordpress(m.Name),
+						MatchExpressions: []metav1.LabelSelectorRequirement{{
+							Key:      "job",
+							Operator: metav1.LabelSelectorOpExists,
+						}},
+					}},
+				},
+			}},
+		},
+	}
+
+	controllerutil.SetControllerReference(m, np, r.scheme)
+
+	return np
+}
+
+// wordpressNetworkPolicyForWordpress returns the NetworkPolicy that only lets
+// the peers in spec.networkPolicy.wordpressFrom reach the WordPress pods of m,
+// or those of defaultWordpressFrom when it is empty.
+func (r *ReconcileWordpress) wordpressNetworkPolicyForWordpress(m *examplev1.Wordpress) *networkingv1.NetworkPolicy {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "frontend"
+
+	port := intstr.FromString("wordpress")
+	protocol := corev1.ProtocolTCP
+
+	var from []networkingv1.NetworkPolicyPeer
+	for _, peer := range wordpressFrom(m) {
+		from = append(from, *peer.DeepCopy())
+	}
+	if len(from) == 0 {
+		from = r.defaultWordpressFrom(m)
+	}
+
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-wordpress", m.Name),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: ls},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+				From:  from,
+			}},
+		},
+	}
+
+	controllerutil.SetControllerReference(m, np, r.scheme)
+
+	return np
+}
+
+func wordpressFrom(m *examplev1.Wordpress) []networkingv1.NetworkPolicyPeer {
+	if m.Spec.NetworkPolicy == nil {
+		return nil
+	}
+	return m.Spec.NetworkPolicy.WordpressFrom
+}
+
+// defaultWordpressFrom returns the peers that may reach WordPress when
+// spec.networkPolicy.wordpressFrom is empty: the pods in the namespace of the
+// site, the ingress controller and, when the WordPress Service is exposed
+// outside the cluster, the clients in spec.wordpress.loadBalancerSourceRanges
+// or, without them, any address.
+func (r *ReconcileWordpress) defaultWordpressFrom(m *examplev1.Wordpress) []networkingv1.NetworkPolicyPeer {
+	from := []networkingv1.NetworkPolicyPeer{
+		{PodSelector: &metav1.LabelSelector{}},
+		{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: r.ingressNamespace}}},
+	}
+	if wordpressServiceType(m) == corev1.ServiceTypeClusterIP {
+		return from
+	}
+	ranges := m.Spec.Wordpress.LoadBalancerSourceRanges
+	if len(ranges) == 0 {
+		ranges = []string{"0.0.0.0/0", "::/0"}
+	}
+	for _, cidr := range ranges {
+		from = append(from, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	return from
+}
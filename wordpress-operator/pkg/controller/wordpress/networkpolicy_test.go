@@ -0,0 +1,149 @@
+package wordpress
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestReconcileIsolatesDatabase(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			NetworkPolicy: &examplev1.NetworkPolicySpec{
+				WordpressFrom: []networkingv1.NetworkPolicyPeer{{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": "ingress-nginx"}},
+				}},
+			},
+		},
+	}
+	r := newTestReconciler(t, wp)
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	db := &networkingv1.NetworkPolicy{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, db); err != nil {
+		t.Fatalf("database NetworkPolicy was not created: %v", err)
+	}
+	allowed := func(ls map[string]string) bool {
+		for _, peer := range db.Spec.Ingress[0].From {
+			selector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if peer.NamespaceSelector == nil && selector.Matches(labels.Set(ls)) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, tt := range []struct {
+		pod     string
+		labels  map[string]string
+		allowed bool
+	}{
+		{"wordpress", map[string]string{"app": "wordpress", "wordpress_cr": "mysite", "tier": "frontend"}, true},
+		{"replica", map[string]string{"app": "wordpress", "wordpress_cr": "mysite", "tier": "mysql"}, true},
+		{"seed job", seedJobLabels("mysite"), true},
+		{"other site", map[string]string{"app": "wordpress", "wordpress_cr": "othersite", "tier": "frontend"}, false},
+		{"unrelated", map[string]string{"app": "debug"}, false},
+	} {
+		if got := allowed(tt.labels); got != tt.allowed {
+			t.Errorf("%s pod may reach the database: %v, want %v", tt.pod, got, tt.allowed)
+		}
+	}
+
+	frontend := &networkingv1.NetworkPolicy{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, frontend); err != nil {
+		t.Fatalf("WordPress NetworkPolicy was not created: %v", err)
+	}
+	if from := frontend.Spec.Ingress[0].From; len(from) != 1 || from[0].NamespaceSelector == nil {
+		t.Errorf("WordPress accepts traffic from %+v, want the ingress controller namespace", from)
+	}
+}
+
+func TestNetworkPoliciesOptOut(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	r.networkPoliciesDisabled = true
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, &networkingv1.NetworkPolicy{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("database NetworkPolicy exists with NetworkPolicies disabled: %v", err)
+	}
+}
+
+func TestWordpressNetworkPolicyDefaults(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		serviceType corev1.ServiceType
+		ranges      []string
+		ipBlocks    []string
+	}{
+		{"behind an Ingress", corev1.ServiceTypeClusterIP, nil, nil},
+		{"load balancer", corev1.ServiceTypeLoadBalancer, nil, []string{"0.0.0.0/0", "::/0"}},
+		{"limited load balancer", corev1.ServiceTypeLoadBalancer, []string{"203.0.113.0/24"}, []string{"203.0.113.0/24"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wp := &examplev1.Wordpress{
+				ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+				Spec: examplev1.WordpressSpec{
+					Wordpress: examplev1.WordpressTierSpec{ServiceType: tc.serviceType, LoadBalancerSourceRanges: tc.ranges},
+				},
+			}
+			r := newTestReconciler(t, wp)
+			if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+				t.Fatalf("reconcile: %v", err)
+			}
+
+			np := &networkingv1.NetworkPolicy{}
+			if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, np); err != nil {
+				t.Fatalf("WordPress NetworkPolicy was not created: %v", err)
+			}
+			sameNamespace, ingress := false, false
+			var ipBlocks []string
+			for _, peer := range np.Spec.Ingress[0].From {
+				switch {
+				case peer.IPBlock != nil:
+					ipBlocks = append(ipBlocks, peer.IPBlock.CIDR)
+				case peer.NamespaceSelector != nil:
+					ingress = peer.NamespaceSelector.MatchLabels[namespaceNameLabel] == "ingress-nginx"
+				case peer.PodSelector != nil:
+					sameNamespace = len(peer.PodSelector.MatchLabels) == 0 && len(peer.PodSelector.MatchExpressions) == 0
+				}
+			}
+			if !sameNamespace || !ingress {
+				t.Errorf("WordPress accepts traffic from %+v, want its namespace and the ingress controller", np.Spec.Ingress[0].From)
+			}
+			if !reflect.DeepEqual(ipBlocks, tc.ipBlocks) {
+				t.Errorf("WordPress accepts traffic from addresses %v, want %v", ipBlocks, tc.ipBlocks)
+			}
+
+			svc := &corev1.Service{}
+			if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, svc); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(svc.Spec.LoadBalancerSourceRanges, tc.ranges) {
+				t.Errorf("Service source ranges = %v, want %v", svc.Spec.LoadBalancerSourceRanges, tc.ranges)
+			}
+		})
+	}
+}
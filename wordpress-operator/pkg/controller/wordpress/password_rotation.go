@@ -0,0 +1,120 @@
+package wordpress
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// restartedAtAnnotation is the pod template annotation `kubectl rollout restart` uses.
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// rotateRootPasswordScript changes the MySQL root password from $OLD_PASSWORD
+// to $NEW_PASSWORD. It exits successfully without changes when the new password
+// is already in effect, so it is safe to retry. ALTER USER is tried first and
+// SET PASSWORD is the fallback for MySQL 5.6.
+const rotateRootPasswordScript = `set -e
+until mysqladmin -h "$MYSQL_HOST" ping --silent; do sleep 2; done
+if MYSQL_PWD="$NEW_PASSWORD" mysql -h "$MYSQL_HOST" -uroot -e 'SELECT 1' >/dev/null 2>&1; then
+  echo "root password is already up to date"
+  exit 0
+fi
+NEW=$(printf '%s' "$NEW_PASSWORD" | sed -e 's/\\/\\\\/g' -e "s/'/\\\\'/g")
+export MYSQL_PWD="$OLD_PASSWORD"
+mysql -h "$MYSQL_HOST" -uroot -e "ALTER USER 'root'@'%' IDENTIFIED BY '$NEW', 'root'@'localhost' IDENTIFIED BY '$NEW'" ||
+  mysql -h "$MYSQL_HOST" -uroot -e "SET PASSWORD FOR 'root'@'%' = PASSWORD('$NEW'); SET PASSWORD FOR 'root'@'localhost' = PASSWORD('$NEW')"
+echo "root password changed"
+`
+
+// rotateRootPassword applies a pending root password change in sec to MySQL.
+// It runs a Job that logs in with the previous password and sets the new one.
+// Once the Job succeeds the previous password is dropped from sec and the
+// WordPress pods are restarted so that they pick up the new password.
+// It reports whether the change is complete.
+func (r *ReconcileWordpress) rotateRootPassword(m *examplev1.Wordpress, sec *corev1.Secret, reqLogger logr.Logger) (bool, error) {
+	job := r.passwordJobForWordpress(m)
+	done, err := r.runJob(job, "change the MySQL root password", reqLogger)
+	if !done || err != nil {
+		return false, err
+	}
+
+	reqLogger.Info("Changed the MySQL root password", "Job.Name", job.Name)
+	data := map[string][]byte{}
+	for key, value := range sec.Data {
+		if key != secretPreviousRootPasswordKey {
+			data[key] = value
+		}
+	}
+	desired := r.secretForWordpress(m, data)
+	if _, err := r.applyChanges(sec, desired); err != nil {
+		return false, err
+	}
+	desired.DeepCopyInto(sec)
+	err = r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	// Environment variables are only read at start-up.
+	dep := &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-wordpress", m.Name), Namespace: m.Namespace}, dep)
+	if errors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if dep.Spec.Template.Annotations == nil {
+		dep.Spec.Template.Annotations = map[string]string{}
+	}
+	dep.Spec.Template.Annotations[restartedAtAnnotation] = time.Now().Format(time.RFC3339)
+	reqLogger.Info("Restarting wordpress to use the new password", "wordpressDep.Name", dep.Name)
+	return true, r.client.Update(context.TODO(), dep)
+}
+
+func (r *ReconcileWordpress) passwordJobForWordpress(m *examplev1.Wordpress) *batchv1.Job {
+	return r.newJob(m, passwordJobName(m), jobLabelsForWordpress(m.Name, "password-change"), 600, corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Image:   mysqlImage(m),
+			Name:    "change-password",
+			Command: []string{"sh", "-c", databaseScript(m, rotateRootPasswordScript)},
+			Env: []corev1.EnvVar{
+				{
+					Name:  "MYSQL_HOST",
+					Value: fmt.Sprintf("%s-mysql", m.Name),
+				},
+				secretEnvVar("OLD_PASSWORD", m.Name, secretPreviousRootPasswordKey),
+				secretEnvVar("NEW_PASSWORD", m.Name, secretRootPasswordKey),
+			},
+		}},
+	})
+}
+
+func passwordJobName(m *examplev1.Wordpress) string {
+	return fmt.Sprintf("%s-mysql-password", m.Name)
+}
+
+// secretEnvVar returns an environment variable read from key of the named Secret.
+func secretEnvVar(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: secretName,
+				},
+				Key: key,
+			},
+		},
+	}
+}
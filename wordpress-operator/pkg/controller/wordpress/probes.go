@@ -0,0 +1,180 @@
+package wordpress
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// defaultHealthCheckPath is the page the readiness probe of WordPress
+// requests. It is served by WordPress itself, so it fails while the database
+// cannot be reached.
+const defaultHealthCheckPath = "/wp-login.php"
+
+// probeSet holds the probes of a container.
+type probeSet struct {
+	startup   *corev1.Probe
+	liveness  *corev1.Probe
+	readiness *corev1.Probe
+}
+
+// databaseProbes returns the probes of the database container of m. They ping
+// the server over TCP as root, so they only pass once the server accepts
+// connections from outside the pod, which it does not while it initializes
+// an empty data directory. The ping succeeds as long as the server answers,
+// even when it refuses a root password that has since been rotated.
+func databaseProbes(m *examplev1.Wordpress) probeSet {
+	engine := engineForWordpress(m)
+	ping := corev1.ProbeHandler{
+		Exec: &corev1.ExecAction{Command: []string{"sh", "-c",
+			fmt.Sprintf(`MYSQL_PWD="$%sROOT_PASSWORD" exec %s ping -u root -h 127.0.0.1 --silent`, engine.envPrefix, engine.admin),
+		}},
+	}
+	return overrideProbes(probeSet{
+		// Initializing an empty data directory takes a while.
+		startup: &corev1.Probe{
+			ProbeHandler:     ping,
+			PeriodSeconds:    10,
+			TimeoutSeconds:   5,
+			FailureThreshold: 30,
+		},
+		liveness: &corev1.Probe{
+			ProbeHandler:     ping,
+			PeriodSeconds:    10,
+			TimeoutSeconds:   5,
+			FailureThreshold: 6,
+		},
+		readiness: &corev1.Probe{
+			ProbeHandler:     ping,
+			PeriodSeconds:    10,
+			TimeoutSeconds:   5,
+			FailureThreshold: 3,
+		},
+	}, m.Spec.Database.Probes)
+}
+
+// wordpressProbes returns the probes of the WordPress container of m. Only
+// the readiness probe asks WordPress for a page: the others check that Apache
+// accepts connections, so that pods are not restarted while the database is
+// down.
+func wordpressProbes(m *examplev1.Wordpress) probeSet {
+	path := m.Spec.Wordpress.HealthCheckPath
+	if path == "" {
+		path = defaultHealthCheckPath
+	}
+	tcp := corev1.ProbeHandler{
+		TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("wordpress")},
+	}
+	return overrideProbes(probeSet{
+		// The first pod copies WordPress to an empty volume before Apache starts.
+		startup: &corev1.Probe{
+			ProbeHandler:     tcp,
+			PeriodSeconds:    5,
+			TimeoutSeconds:   5,
+			FailureThreshold: 60,
+		},
+		liveness: &corev1.Probe{
+			ProbeHandler:     tcp,
+			PeriodSeconds:    10,
+			TimeoutSeconds:   5,
+			FailureThreshold: 6,
+		},
+		readiness: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromString("wordpress")},
+			},
+			PeriodSeconds:    10,
+			TimeoutSeconds:   5,
+			FailureThreshold: 3,
+		},
+	}, m.Spec.Wordpress.Probes)
+}
+
+// overrideProbes applies the timings set in spec to probes.
+func overrideProbes(probes probeSet, spec *examplev1.ProbesSpec) probeSet {
+	if spec == nil {
+		return probes
+	}
+	overrideProbe(probes.startup, spec.Startup)
+	overrideProbe(probes.liveness, spec.Liveness)
+	overrideProbe(probes.readiness, spec.Readiness)
+	return probes
+}
+
+func overrideProbe(probe *corev1.Probe, spec *examplev1.ProbeSpec) {
+	if spec == nil {
+		return
+	}
+	if spec.InitialDelaySeconds != nil {
+		probe.InitialDelaySeconds = *spec.InitialDelaySeconds
+	}
+	if spec.PeriodSeconds != nil {
+		probe.PeriodSeconds = *spec.PeriodSeconds
+	}
+	if spec.TimeoutSeconds != nil {
+		probe.TimeoutSeconds = *spec.TimeoutSeconds
+	}
+	if spec.FailureThreshold != nil {
+		probe.FailureThreshold = *spec.FailureThreshold
+	}
+}
+
+// unreadyPods returns the pods of the tier of m that fail their probes, each
+// with what is wrong with it.
+func (r *ReconcileWordpress) unreadyPods(m *examplev1.Wordpress, tier string) ([]string, error) {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = tier
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(m.Namespace), client.MatchingLabels(ls))
+	if err != nil {
+		return nil, err
+	}
+
+	var unready []string
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || podReady(&pod) {
+			continue
+		}
+		unready = append(unready, fmt.Sprintf("%s (%s)", pod.Name, podProblem(&pod)))
+	}
+	sort.Strings(unready)
+	return unready, nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podProblem describes why pod is not ready.
+func podProblem(pod *corev1.Pod) string {
+	if pod.Status.Phase == corev1.PodPending {
+		return "pending"
+	}
+	var problems []string
+	for _, c := range pod.Status.ContainerStatuses {
+		switch {
+		case c.State.Waiting != nil:
+			problems = append(problems, fmt.Sprintf("%s is %s", c.Name, c.State.Waiting.Reason))
+		case c.Started != nil && !*c.Started:
+			problems = append(problems, fmt.Sprintf("%s has not passed its startup probe", c.Name))
+		case !c.Ready:
+			problems = append(problems, fmt.Sprintf("%s fails its readiness probe", c.Name))
+		}
+	}
+	if len(problems) == 0 {
+		return "not ready"
+	}
+	return strings.Join(problems, ", ")
+}
@@ -0,0 +1,78 @@
+package wordpress
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestWordpressProbes(t *testing.T) {
+	period := int32(30)
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Wordpress: examplev1.WordpressTierSpec{
+				HealthCheckPath: "/healthz.php",
+				Probes: &examplev1.ProbesSpec{
+					Readiness: &examplev1.ProbeSpec{PeriodSeconds: &period},
+				},
+			},
+		},
+	}
+
+	probes := wordpressProbes(wp)
+	if get := probes.readiness.HTTPGet; get == nil || get.Path != "/healthz.php" {
+		t.Errorf("readiness probe = %+v, want a request for /healthz.php", probes.readiness.ProbeHandler)
+	}
+	if probes.readiness.PeriodSeconds != 30 || probes.readiness.FailureThreshold != 3 {
+		t.Errorf("readiness probe = %+v, want the period overridden and the other timings kept", probes.readiness)
+	}
+	// A database outage must not restart WordPress.
+	if probes.liveness.TCPSocket == nil {
+		t.Errorf("liveness probe = %+v, want a TCP check", probes.liveness.ProbeHandler)
+	}
+	if wordpressProbes(&examplev1.Wordpress{}).readiness.HTTPGet.Path != defaultHealthCheckPath {
+		t.Error("readiness probe does not default to the login page")
+	}
+}
+
+func TestReconcileReportsUnreadyPods(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	ls := labelsForWordpress("mysite")
+	ls["tier"] = "frontend"
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite-wordpress-abc", Namespace: "default", Labels: ls},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "wordpress",
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}},
+		},
+	}
+	r := newTestReconciler(t, wp, pod)
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	c := meta.FindStatusCondition(got.Status.Conditions, examplev1.ConditionWordPressReady)
+	if c == nil || c.Status == metav1.ConditionTrue || !strings.Contains(c.Message, "mysite-wordpress-abc (wordpress fails its readiness probe)") {
+		t.Errorf("condition %s = %+v, want the pod failing its probe named", examplev1.ConditionWordPressReady, c)
+	}
+}
@@ -0,0 +1,242 @@
+package wordpress
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// replicationServerScript starts the database server named by $1 with a
+// binary log and a server id of its own, which it derives from the ordinal of
+// its pod. Every pod but the first is a read replica.
+const replicationServerScript = `set -e
+ordinal=${HOSTNAME##*-}
+read_only=0
+if [ "$ordinal" -gt 0 ]; then read_only=1; fi
+exec docker-entrypoint.sh "$1" --server-id=$((100 + ordinal)) --log-bin=mysql-bin --relay-log=relay-bin --binlog-format=ROW --read-only=$read_only
+`
+
+// replicationSyntaxScript defines replication_syntax, which sets the words the
+// server reached with the mysql options "$@" uses for replication. MySQL
+// 8.0.26 and later call the primary the source and its copies replicas, and
+// 8.4 no longer understands anything else; MariaDB and older MySQL releases
+// only know the master and slave statements.
+const replicationSyntaxScript = `replication_syntax() {
+  if mysql "$@" -uroot -N -e 'SELECT VERSION()' | awk -F'[.-]' '!/MariaDB/ && ($1 > 8 || ($1 == 8 && ($2 > 0 || $3 >= 26))) { found = 1 } END { exit !found }'; then
+    replica=REPLICA source=SOURCE change="CHANGE REPLICATION SOURCE TO" source_data=--source-data=1 source_key=", GET_SOURCE_PUBLIC_KEY=1"
+  else
+    replica=SLAVE source=MASTER change="CHANGE MASTER TO" source_data=--master-data=1 source_key=
+  fi
+}
+`
+
+// seedReplicaScript makes $REPLICA_HOST replicate from $PRIMARY_HOST. It sets
+// up the replication user with password $REPLICATION_PASSWORD on the primary,
+// copies a consistent snapshot of the primary, including the binary log
+// position it was taken at, to the replica and starts replicating. A replica
+// whose SQL thread is already running is left alone; one that is not is
+// seeded again from scratch.
+const seedReplicaScript = replicationSyntaxScript + `set -e
+until mysqladmin -h "$PRIMARY_HOST" ping --silent; do sleep 2; done
+until mysqladmin -h "$REPLICA_HOST" ping --silent; do sleep 2; done
+replication_syntax -h "$REPLICA_HOST"
+if mysql -h "$REPLICA_HOST" -uroot -e "SHOW $replica STATUS\G" | grep -qE '(Slave|Replica)_SQL_Running: Yes'; then
+  echo "replica is already replicating"
+  exit 0
+fi
+PW=$(printf '%s' "$REPLICATION_PASSWORD" | sed -e 's/\\/\\\\/g' -e "s/'/\\\\'/g")
+if mysql -h "$PRIMARY_HOST" -uroot -e "CREATE USER IF NOT EXISTS 'replication'@'%' IDENTIFIED BY '$PW'"; then
+  mysql -h "$PRIMARY_HOST" -uroot -e "ALTER USER 'replication'@'%' IDENTIFIED BY '$PW'"
+else
+  mysql -h "$PRIMARY_HOST" -uroot -e "GRANT USAGE ON *.* TO 'replication'@'%' IDENTIFIED BY '$PW'"
+fi
+mysql -h "$PRIMARY_HOST" -uroot -e "GRANT REPLICATION SLAVE ON *.* TO 'replication'@'%'"
+mysql -h "$REPLICA_HOST" -uroot -e "STOP $replica; RESET $replica ALL; $change ${source}_HOST='$PRIMARY_HOST', ${source}_USER='replication', ${source}_PASSWORD='$PW'$source_key"
+{ mysqldump -h "$PRIMARY_HOST" -uroot --all-databases --single-transaction $source_data --routines --events; echo $? >/tmp/dump-status; } | mysql -h "$REPLICA_HOST" -uroot
+if [ "$(cat /tmp/dump-status)" != 0 ]; then echo "dumping the primary failed" >&2; exit 1; fi
+mysql -h "$REPLICA_HOST" -uroot -e "FLUSH PRIVILEGES; START $replica"
+echo "replica is replicating"
+`
+
+// ensureReplicas seeds each read replica run by mysqlSts with a Job. The
+// completed Jobs are kept as the record that the replicas were seeded; the
+// Jobs of replicas that were scaled away are deleted. It reports whether
+// every replica is seeded.
+func (r *ReconcileWordpress) ensureReplicas(m *examplev1.Wordpress, mysqlSts *appsv1.StatefulSet, reqLogger logr.Logger) (bool, error) {
+	replicas := databaseReplicas(mysqlSts)
+	desired := map[string]bool{}
+	done := true
+	for i := int32(1); i <= replicas; i++ {
+		job := r.seedJobForWordpress(m, i)
+		desired[job.Name] = true
+		seeded, err := r.runJob(job, "seed read replica "+replicaPodName(m, i), reqLogger)
+		if err != nil {
+			return false, err
+		}
+		done = done && seeded
+	}
+
+	jobs := &batchv1.JobList{}
+	err := r.client.List(context.TODO(), jobs, client.InNamespace(m.Namespace), client.MatchingLabels(seedJobLabels(m.Name)))
+	if err != nil {
+		return false, err
+	}
+	for i := range jobs.Items {
+		if desired[jobs.Items[i].Name] || jobs.Items[i].DeletionTimestamp != nil {
+			continue
+		}
+		reqLogger.Info("Deleting the seed Job of a removed replica", "Job.Name", jobs.Items[i].Name)
+		err = r.client.Delete(context.TODO(), &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	return done, nil
+}
+
+func (r *ReconcileWordpress) seedJobForWordpress(m *examplev1.Wordpress, ordinal int32) *batchv1.Job {
+	return r.newJob(m, fmt.Sprintf("%s-mysql-seed-%d", m.Name, ordinal), seedJobLabels(m.Name), 3600, corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Image:   mysqlImage(m),
+			Name:    "seed",
+			Command: []string{"sh", "-c", databaseScript(m, seedReplicaScript)},
+			Env: []corev1.EnvVar{
+				{Name: "PRIMARY_HOST", Value: databasePodHost(m, primaryPodName(m))},
+				{Name: "REPLICA_HOST", Value: databasePodHost(m, replicaPodName(m, ordinal))},
+				secretEnvVar("MYSQL_PWD", m.Name, secretRootPasswordKey),
+				secretEnvVar("REPLICATION_PASSWORD", m.Name, secretReplicationPasswordKey),
+			},
+		}},
+	})
+}
+
+func seedJobLabels(name string) map[string]string {
+	return jobLabelsForWordpress(name, "replica-seed")
+}
+
+// databaseReplicas returns the number of read replicas mysqlSts runs.
+func databaseReplicas(mysqlSts *appsv1.StatefulSet) int32 {
+	if mysqlSts.Spec.Replicas == nil || *mysqlSts.Spec.Replicas < 1 {
+		return 0
+	}
+	return *mysqlSts.Spec.Replicas - 1
+}
+
+func primaryPodName(m *examplev1.Wordpress) string {
+	return replicaPodName(m, 0)
+}
+
+func replicaPodName(m *examplev1.Wordpress, ordinal int32) string {
+	return fmt.Sprintf("%s-mysql-%d", m.Name, ordinal)
+}
+
+// databasePodHost returns the host name of the named database pod, which the
+// headless Service gives it.
+func databasePodHost(m *examplev1.Wordpress, pod string) string {
+	return fmt.Sprintf("%s.%s", pod, mysqlHeadlessServiceName(m))
+}
+
+// observeReplication asks each read replica run by mysqlSts how replication
+// is going. A replica that cannot be asked is reported with the error. The
+// root password is read from the Secret of m rather than from the environment
+// of the pods, which keeps the one they started with after a rotation.
+func (r *ReconcileWordpress) observeReplication(m *examplev1.Wordpress, mysqlSts *appsv1.StatefulSet) ([]examplev1.ReplicaStatus, error) {
+	sec := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, sec)
+	if err != nil {
+		return nil, err
+	}
+	rootPassword := sec.Data[secretRootPasswordKey]
+
+	command := []string{"sh", "-c", databaseScript(m, replicationSyntaxScript+`MYSQL_PWD="$(cat)"
+export MYSQL_PWD
+replication_syntax
+exec mysql -uroot -e "SHOW $replica STATUS\G"
+`)}
+
+	var replicas []examplev1.ReplicaStatus
+	for i := int32(1); i <= databaseReplicas(mysqlSts); i++ {
+		name := replicaPodName(m, i)
+		out, err := r.exec.Exec(m.Namespace, name, "mysql", command, bytes.NewReader(rootPassword))
+		if err != nil {
+			replicas = append(replicas, examplev1.ReplicaStatus{
+				Name:      name,
+				LastError: fmt.Sprintf("could not query replication status: %v", err),
+			})
+			continue
+		}
+		replicas = append(replicas, parseReplicaStatus(name, out))
+	}
+	return replicas, nil
+}
+
+// parseReplicaStatus reads the output of SHOW REPLICA STATUS\G, or of SHOW
+// SLAVE STATUS\G on older servers, on the replica running in the named pod.
+func parseReplicaStatus(name, out string) examplev1.ReplicaStatus {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) == 2 {
+			fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	// field returns the value of the first of names the server reported.
+	field := func(names ...string) string {
+		for _, name := range names {
+			if v, ok := fields[name]; ok {
+				return v
+			}
+		}
+		return ""
+	}
+
+	replica := examplev1.ReplicaStatus{
+		Name:             name,
+		IOThreadRunning:  field("Replica_IO_Running", "Slave_IO_Running") == "Yes",
+		SQLThreadRunning: field("Replica_SQL_Running", "Slave_SQL_Running") == "Yes",
+	}
+	if lag, err := strconv.ParseInt(field("Seconds_Behind_Source", "Seconds_Behind_Master"), 10, 64); err == nil {
+		replica.SecondsBehindPrimary = &lag
+	}
+	switch {
+	case len(fields) == 0:
+		replica.LastError = "replication is not set up"
+	case fields["Last_IO_Error"] != "":
+		replica.LastError = fields["Last_IO_Error"]
+	case fields["Last_SQL_Error"] != "":
+		replica.LastError = fields["Last_SQL_Error"]
+	}
+	return replica
+}
+
+// replicationHealthy reports whether every replica in replicas is replicating,
+// and otherwise which are not.
+func replicationHealthy(replicas []examplev1.ReplicaStatus) (bool, string) {
+	var unhealthy []string
+	for _, rs := range replicas {
+		if rs.IOThreadRunning && rs.SQLThreadRunning {
+			continue
+		}
+		msg := rs.Name
+		if rs.LastError != "" {
+			msg += " (" + rs.LastError + ")"
+		}
+		unhealthy = append(unhealthy, msg)
+	}
+	return len(unhealthy) == 0, "Replicas not replicating: " + strings.Join(unhealthy, ", ")
+}
@@ -0,0 +1,237 @@
+package wordpress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestMysqlStatefulSetWithReplicas(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Database: examplev1.DatabaseSpec{Replicas: 2},
+		},
+	}
+	r := newTestReconciler(t)
+
+	sts := r.mysqlStatefulSetForWordpress(wp, "")
+	if *sts.Spec.Replicas != 3 {
+		t.Errorf("replicas = %d, want the primary and 2 read replicas", *sts.Spec.Replicas)
+	}
+	if cmd := sts.Spec.Template.Spec.Containers[0].Command; len(cmd) == 0 || cmd[len(cmd)-1] != "mysqld" {
+		t.Errorf("command = %v, want mysqld started with replication settings", cmd)
+	}
+
+	// A legacy claim only holds the primary.
+	legacy := r.mysqlStatefulSetForWordpress(wp, "mysite-mysql")
+	if *legacy.Spec.Replicas != 1 {
+		t.Errorf("replicas = %d on a legacy claim, want 1", *legacy.Spec.Replicas)
+	}
+
+	// Writes go to the primary only; reads to every server.
+	write := r.mysqlServiceForWordpress(wp)
+	if write.Spec.Selector[appsv1.StatefulSetPodNameLabel] != "mysite-mysql-0" {
+		t.Errorf("write Service selects %v, want the primary only", write.Spec.Selector)
+	}
+	read := r.mysqlReadServiceForWordpress(wp)
+	if _, ok := read.Spec.Selector[appsv1.StatefulSetPodNameLabel]; ok {
+		t.Errorf("read Service selects %v, want every database pod", read.Spec.Selector)
+	}
+}
+
+func TestParseReplicaStatus(t *testing.T) {
+	out := `*************************** 1. row ***************************
+               Slave_IO_State: Waiting for master to send event
+                  Master_Host: mysite-mysql-0.mysite-mysql-headless
+             Slave_IO_Running: Yes
+            Slave_SQL_Running: No
+                Last_SQL_Error: Error 'Duplicate entry' on query
+        Seconds_Behind_Master: NULL
+                Last_IO_Error:
+`
+	got := parseReplicaStatus("mysite-mysql-1", out)
+	if !got.IOThreadRunning || got.SQLThreadRunning {
+		t.Errorf("threads = IO %v, SQL %v; want IO running and SQL stopped", got.IOThreadRunning, got.SQLThreadRunning)
+	}
+	if got.SecondsBehindPrimary != nil {
+		t.Errorf("lag = %d, want unknown", *got.SecondsBehindPrimary)
+	}
+	if got.LastError != "Error 'Duplicate entry' on query" {
+		t.Errorf("last error = %q", got.LastError)
+	}
+	if healthy, _ := replicationHealthy([]examplev1.ReplicaStatus{got}); healthy {
+		t.Error("a replica whose SQL thread stopped is reported healthy")
+	}
+
+	got = parseReplicaStatus("mysite-mysql-1", "Slave_IO_Running: Yes\nSlave_SQL_Running: Yes\nSeconds_Behind_Master: 3\n")
+	if got.SecondsBehindPrimary == nil || *got.SecondsBehindPrimary != 3 {
+		t.Errorf("lag = %v, want 3 seconds", got.SecondsBehindPrimary)
+	}
+	if healthy, _ := replicationHealthy([]examplev1.ReplicaStatus{got}); !healthy {
+		t.Error("a replicating replica is reported unhealthy")
+	}
+
+	// MySQL 8.4 only reports the columns named after sources and replicas.
+	out = `*************************** 1. row ***************************
+             Replica_IO_State: Waiting for source to send event
+                  Source_Host: mysite-mysql-0.mysite-mysql-headless
+           Replica_IO_Running: Yes
+          Replica_SQL_Running: Yes
+        Seconds_Behind_Source: 7
+                Last_IO_Error:
+               Last_SQL_Error:
+`
+	got = parseReplicaStatus("mysite-mysql-1", out)
+	if !got.IOThreadRunning || !got.SQLThreadRunning {
+		t.Errorf("threads = IO %v, SQL %v; want both running", got.IOThreadRunning, got.SQLThreadRunning)
+	}
+	if got.SecondsBehindPrimary == nil || *got.SecondsBehindPrimary != 7 {
+		t.Errorf("lag = %v, want 7 seconds", got.SecondsBehindPrimary)
+	}
+	if got.LastError != "" {
+		t.Errorf("last error = %q, want none", got.LastError)
+	}
+
+	// A replica that was never seeded has no replication status at all.
+	if got := parseReplicaStatus("mysite-mysql-1", ""); got.LastError == "" {
+		t.Error("a replica without replication is not reported as such")
+	}
+}
+
+// passwordExecutor stands in for the database pods: it answers as a healthy
+// replica to commands fed the root password in effect, and refuses the rest.
+type passwordExecutor struct {
+	password string
+}
+
+func (e passwordExecutor) Exec(namespace, pod, container string, command []string, stdin io.Reader) (string, error) {
+	if stdin == nil {
+		return "", fmt.Errorf("ERROR 1045 (28000): Access denied for user 'root'@'localhost' (using password: NO)")
+	}
+	given, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return "", err
+	}
+	if string(given) != e.password {
+		return "", fmt.Errorf("ERROR 1045 (28000): Access denied for user 'root'@'localhost' (using password: YES)")
+	}
+	return "Slave_IO_Running: Yes\nSlave_SQL_Running: Yes\nSeconds_Behind_Master: 0\n", nil
+}
+
+func TestObserveReplicationAfterRotation(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Database: examplev1.DatabaseSpec{Replicas: 1},
+		},
+	}
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Data: map[string][]byte{
+			secretRootPasswordKey:         []byte("new-password"),
+			secretPreviousRootPasswordKey: []byte("old-password"),
+		},
+	}
+	r := newTestReconciler(t, wp, sec)
+	job := r.passwordJobForWordpress(wp)
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := r.client.Create(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+	// The database pods keep the old password in their environment until
+	// they restart; only the server has changed to the new one.
+	r.exec = passwordExecutor{password: "new-password"}
+
+	done, err := r.rotateRootPassword(wp, sec, logr.Discard())
+	if err != nil || !done {
+		t.Fatalf("rotateRootPassword = %v, %v; want the rotation complete", done, err)
+	}
+	got := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, pending := got.Data[secretPreviousRootPasswordKey]; pending || string(got.Data[secretRootPasswordKey]) != "new-password" {
+		t.Errorf("Secret data = %v, want only the new password left", got.Data)
+	}
+
+	replicas, err := r.observeReplication(wp, r.mysqlStatefulSetForWordpress(wp, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replicas) != 1 {
+		t.Fatalf("replicas = %+v, want the one read replica", replicas)
+	}
+	if healthy, message := replicationHealthy(replicas); !healthy {
+		t.Errorf("replication is reported unhealthy after a rotation: %s", message)
+	}
+}
+
+func TestReplicaClaimsFollowTheSite(t *testing.T) {
+	size := resource.MustParse("30Gi")
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default", UID: "1234"},
+		Spec: examplev1.WordpressSpec{
+			Database: examplev1.DatabaseSpec{Replicas: 1},
+			Storage:  examplev1.StorageSpec{Database: examplev1.VolumeSpec{Size: &size}},
+		},
+	}
+	r := newTestReconciler(t, wp)
+	if err := r.client.Create(context.TODO(), r.mysqlStatefulSetForWordpress(wp, "")); err != nil {
+		t.Fatal(err)
+	}
+	// The StatefulSet made the claims of the replicas from its template, so
+	// they have no owner; the second replica has since been scaled away.
+	for _, name := range []string{"data-mysite-mysql-1", "data-mysite-mysql-2"} {
+		pvc := r.mysqlPVCForWordpress(wp, name)
+		pvc.OwnerReferences = nil
+		pvc.Spec = pvcSpecForVolume(examplev1.VolumeSpec{})
+		if err := r.client.Create(context.TODO(), pvc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reqLogger := log.WithValues("Request.Name", "mysite")
+	if err := r.ensureStorage(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+
+	replica := &corev1.PersistentVolumeClaim{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "data-mysite-mysql-1", Namespace: "default"}, replica); err != nil {
+		t.Fatal(err)
+	}
+	if len(replica.OwnerReferences) != 1 || replica.OwnerReferences[0].UID != wp.UID {
+		t.Errorf("owner references = %+v, want the Wordpress", replica.OwnerReferences)
+	}
+	if got := replica.Spec.Resources.Requests[corev1.ResourceStorage]; got.Cmp(size) != 0 {
+		t.Errorf("replica claim requests %s, want it expanded to %s", got.String(), size.String())
+	}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: "data-mysite-mysql-2", Namespace: "default"}, &corev1.PersistentVolumeClaim{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("claim of the removed replica: %v, want it deleted", err)
+	}
+
+	if err := r.retainData(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+	replica = &corev1.PersistentVolumeClaim{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "data-mysite-mysql-1", Namespace: "default"}, replica); err != nil {
+		t.Fatal(err)
+	}
+	if len(replica.OwnerReferences) != 0 || replica.Labels[retainedLabel] != "mysite" {
+		t.Errorf("replica claim = %v owned by %+v, want it retained", replica.Labels, replica.OwnerReferences)
+	}
+}
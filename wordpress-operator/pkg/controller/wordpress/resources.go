@@ -0,0 +1,113 @@
+package wordpress
+
+import (
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+const (
+	// defaultSizeEnvVar sets the size profile of sites that do not choose one.
+	defaultSizeEnvVar = "DEFAULT_SIZE_PROFILE"
+	// defaultSize is the size profile of sites when the operator is not
+	// configured with one.
+	defaultSize = examplev1.SizeSmall
+)
+
+// sizeProfile holds the resources of each tier of a size.
+type sizeProfile struct {
+	wordpress corev1.ResourceRequirements
+	database  corev1.ResourceRequirements
+}
+
+// sizeProfiles are the sizes a site can choose from. Requests leave room for
+// bursts up to the limits; memory is sized for the InnoDB buffer pool and the
+// Apache workers respectively.
+var sizeProfiles = map[examplev1.SizeProfile]sizeProfile{
+	examplev1.SizeSmall: {
+		wordpress: resourceRequirements("100m", "256Mi", "500m", "512Mi"),
+		database:  resourceRequirements("100m", "256Mi", "500m", "1Gi"),
+	},
+	examplev1.SizeMedium: {
+		wordpress: resourceRequirements("250m", "512Mi", "1", "1Gi"),
+		database:  resourceRequirements("500m", "1Gi", "1", "2Gi"),
+	},
+	examplev1.SizeLarge: {
+		wordpress: resourceRequirements("500m", "1Gi", "2", "2Gi"),
+		database:  resourceRequirements("1", "2Gi", "2", "4Gi"),
+	},
+}
+
+// operatorDefaultSize returns the size profile the operator is configured to
+// give sites that do not choose one.
+func operatorDefaultSize() (examplev1.SizeProfile, error) {
+	size := examplev1.SizeProfile(os.Getenv(defaultSizeEnvVar))
+	if size == "" {
+		return defaultSize, nil
+	}
+	if _, ok := sizeProfiles[size]; !ok {
+		return "", fmt.Errorf("%s: unknown size profile %q", defaultSizeEnvVar, size)
+	}
+	return size, nil
+}
+
+// siteSize returns the size profile of m.
+func (r *ReconcileWordpress) siteSize(m *examplev1.Wordpress) sizeProfile {
+	if p, ok := sizeProfiles[m.Spec.Size]; ok {
+		return p
+	}
+	if p, ok := sizeProfiles[r.defaultSize]; ok {
+		return p
+	}
+	return sizeProfiles[defaultSize]
+}
+
+// wordpressResources returns the resources of the WordPress container of m.
+func (r *ReconcileWordpress) wordpressResources(m *examplev1.Wordpress) corev1.ResourceRequirements {
+	if res := m.Spec.Wordpress.Resources; res != nil {
+		return *res.DeepCopy()
+	}
+	size := r.siteSize(m)
+	return *size.wordpress.DeepCopy()
+}
+
+// databaseResources returns the resources of the database container of m.
+func (r *ReconcileWordpress) databaseResources(m *examplev1.Wordpress) corev1.ResourceRequirements {
+	if res := m.Spec.Database.Resources; res != nil {
+		return *res.DeepCopy()
+	}
+	size := r.siteSize(m)
+	return *size.database.DeepCopy()
+}
+
+// jobResources returns the resources of the containers of Jobs, which only
+// run database clients and copy files. Memory is limited generously, since a
+// backup streams through it.
+func jobResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("50m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	}
+}
+
+func resourceRequirements(cpuRequest, memoryRequest, cpuLimit, memoryLimit string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpuRequest),
+			corev1.ResourceMemory: resource.MustParse(memoryRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpuLimit),
+			corev1.ResourceMemory: resource.MustParse(memoryLimit),
+		},
+	}
+}
@@ -0,0 +1,84 @@
+package wordpress
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestReconcileSizesContainers(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Size: examplev1.SizeLarge,
+			Wordpress: examplev1.WordpressTierSpec{
+				Resources: &corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("3Gi")},
+				},
+			},
+		},
+	}
+	r := newTestReconciler(t, wp)
+	r.defaultSize = examplev1.SizeMedium
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	// The database follows the size of the site.
+	sts := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, sts); err != nil {
+		t.Fatal(err)
+	}
+	got := sts.Spec.Template.Spec.Containers[0].Resources
+	if memory := got.Limits[corev1.ResourceMemory]; memory.String() != "4Gi" {
+		t.Errorf("mysql memory limit = %s, want 4Gi of the large profile", memory.String())
+	}
+
+	// WordPress resources replace the profile.
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	got = dep.Spec.Template.Spec.Containers[0].Resources
+	if memory := got.Requests[corev1.ResourceMemory]; memory.String() != "3Gi" || len(got.Limits) != 0 {
+		t.Errorf("wordpress resources = %+v, want only the 3Gi memory request", got)
+	}
+}
+
+func TestSiteSizeDefaultsToOperatorProfile(t *testing.T) {
+	r := newTestReconciler(t)
+	wp := &examplev1.Wordpress{ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"}}
+
+	if got := r.databaseResources(wp); got.Requests.Memory().String() != "256Mi" {
+		t.Errorf("unconfigured operator gives memory request %s, want 256Mi of the small profile", got.Requests.Memory())
+	}
+
+	r.defaultSize = examplev1.SizeMedium
+	if got := r.wordpressResources(wp); got.Limits.Cpu().String() != "1" {
+		t.Errorf("cpu limit = %s, want 1 of the medium profile", got.Limits.Cpu())
+	}
+}
+
+func TestOperatorDefaultSize(t *testing.T) {
+	defer os.Unsetenv(defaultSizeEnvVar)
+
+	os.Setenv(defaultSizeEnvVar, "large")
+	if size, err := operatorDefaultSize(); err != nil || size != examplev1.SizeLarge {
+		t.Errorf("operatorDefaultSize() = %q, %v; want large", size, err)
+	}
+
+	os.Setenv(defaultSizeEnvVar, "huge")
+	if _, err := operatorDefaultSize(); err == nil {
+		t.Error("an unknown profile is accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package wordpress
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// applyScheduling sets the scheduling of a tier on the pod spec of its pods.
+func applyScheduling(spec *corev1.PodSpec, scheduling examplev1.SchedulingSpec) {
+	scheduling = *scheduling.DeepCopy()
+	spec.NodeSelector = scheduling.NodeSelector
+	spec.Tolerations = scheduling.Tolerations
+	spec.Affinity = scheduling.Affinity
+	spec.TopologySpreadConstraints = scheduling.TopologySpreadConstraints
+	spec.PriorityClassName = scheduling.PriorityClassName
+}
+
+// wordpressScheduling returns the scheduling of the WordPress pods of m.
+// Unless the spec gives an affinity, pods that may run next to each other
+// prefer different nodes, so that losing a node does not take the site down.
+func wordpressScheduling(m *examplev1.Wordpress) examplev1.SchedulingSpec {
+	scheduling := m.Spec.Wordpress.SchedulingSpec
+	if scheduling.Affinity != nil || wordpressMaxReplicas(m) < 2 {
+		return scheduling
+	}
+
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "frontend"
+	scheduling.Affinity = &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: ls},
+					TopologyKey:   corev1.LabelHostname,
+				},
+			}},
+		},
+	}
+	return scheduling
+}
@@ -0,0 +1,69 @@
+package wordpress
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestSchedulingIsRenderedIntoPodTemplates(t *testing.T) {
+	replicas := int32(3)
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Wordpress: examplev1.WordpressTierSpec{
+				Replicas: &replicas,
+				SchedulingSpec: examplev1.SchedulingSpec{
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{
+						MaxSkew:           1,
+						TopologyKey:       corev1.LabelZoneFailureDomainStable,
+						WhenUnsatisfiable: corev1.ScheduleAnyway,
+					}},
+				},
+			},
+			Database: examplev1.DatabaseSpec{
+				SchedulingSpec: examplev1.SchedulingSpec{
+					NodeSelector:      map[string]string{"disktype": "ssd"},
+					Tolerations:       []corev1.Toleration{{Key: "dedicated", Value: "database", Effect: corev1.TaintEffectNoSchedule}},
+					PriorityClassName: "databases",
+				},
+			},
+		},
+	}
+	r := newTestReconciler(t)
+
+	db := r.mysqlStatefulSetForWordpress(wp, "").Spec.Template.Spec
+	if db.NodeSelector["disktype"] != "ssd" || len(db.Tolerations) != 1 || db.PriorityClassName != "databases" {
+		t.Errorf("database pod spec = %+v, want it pinned to SSD nodes", db)
+	}
+	if db.Affinity != nil {
+		t.Errorf("database affinity = %+v, want none", db.Affinity)
+	}
+
+	frontend := r.wordpressDeploymentForWordpress(wp).Spec.Template.Spec
+	if len(frontend.TopologySpreadConstraints) != 1 {
+		t.Errorf("topology spread constraints = %+v, want the zone spread", frontend.TopologySpreadConstraints)
+	}
+	// More than one WordPress pod prefers distinct nodes by default.
+	if frontend.Affinity == nil || frontend.Affinity.PodAntiAffinity == nil ||
+		frontend.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm.TopologyKey != corev1.LabelHostname {
+		t.Errorf("wordpress affinity = %+v, want anti-affinity across nodes", frontend.Affinity)
+	}
+
+	// An affinity in the spec replaces the default.
+	wp.Spec.Wordpress.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+	frontend = r.wordpressDeploymentForWordpress(wp).Spec.Template.Spec
+	if frontend.Affinity.PodAntiAffinity != nil {
+		t.Errorf("wordpress affinity = %+v, want the one of the spec", frontend.Affinity)
+	}
+
+	// A single pod has no one to avoid.
+	wp.Spec.Wordpress.Affinity = nil
+	wp.Spec.Wordpress.Replicas = nil
+	if frontend = r.wordpressDeploymentForWordpress(wp).Spec.Template.Spec; frontend.Affinity != nil {
+		t.Errorf("wordpress affinity = %+v, want none for a single pod", frontend.Affinity)
+	}
+}
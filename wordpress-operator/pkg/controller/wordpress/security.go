@@ -0,0 +1,172 @@
+package wordpress
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+const (
+	// The unprivileged users of the official images, www-data and mysql.
+	wordpressUser = int64(33)
+	databaseUser  = int64(999)
+
+	// Apache cannot bind port 80 without privileges, so under the Restricted
+	// profile it listens on restrictedWordpressPort.
+	defaultWordpressPort    = 80
+	restrictedWordpressPort = 8080
+
+	apacheConfigVolume = "apache-config"
+)
+
+// apacheConfigScript copies the Apache configuration of the image to
+// /apache2, moving it from port 80 to 8080.
+const apacheConfigScript = `set -e
+cp -a /etc/apache2/. /apache2/
+sed -i 's/^Listen 80$/Listen 8080/' /apache2/ports.conf
+sed -i 's/<VirtualHost \*:80>/<VirtualHost *:8080>/' /apache2/sites-available/*.conf
+`
+
+// writableDir is an emptyDir mounted into the containers of a pod whose root
+// filesystem is read-only.
+type writableDir struct {
+	name string
+	path string
+}
+
+var (
+	wordpressWritableDirs = []writableDir{{"tmp", "/tmp"}, {"apache-run", "/var/run/apache2"}, {"apache-lock", "/var/lock/apache2"}}
+	databaseWritableDirs  = []writableDir{{"tmp", "/tmp"}, {"mysqld-run", "/var/run/mysqld"}}
+	jobWritableDirs       = []writableDir{{"tmp", "/tmp"}}
+)
+
+// restricted reports whether the pods of m meet the restricted Pod Security
+// Standard.
+func restricted(m *examplev1.Wordpress) bool {
+	return m.Spec.SecurityProfile == examplev1.SecurityProfileRestricted
+}
+
+// hardenPod makes the pods of template meet the restricted Pod Security
+// Standard. They run as user under the RuntimeDefault seccomp profile, with
+// volumes owned by group, and every container gets an emptyDir at each of
+// dirs.
+func hardenPod(template *corev1.PodTemplateSpec, user, group int64, dirs []writableDir) {
+	nonRoot := true
+	template.Spec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsNonRoot:   &nonRoot,
+		RunAsUser:      &user,
+		RunAsGroup:     &user,
+		FSGroup:        &group,
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+
+	for _, dir := range dirs {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name:         dir.name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+	harden := func(c *corev1.Container) {
+		noEscalation := false
+		readOnly := true
+		c.SecurityContext = &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &noEscalation,
+			ReadOnlyRootFilesystem:   &readOnly,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		}
+		for _, dir := range dirs {
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: dir.name, MountPath: dir.path})
+		}
+	}
+	for i := range template.Spec.InitContainers {
+		harden(&template.Spec.InitContainers[i])
+	}
+	for i := range template.Spec.Containers {
+		harden(&template.Spec.Containers[i])
+	}
+}
+
+// hardenWordpressPod makes the WordPress pods of m meet the restricted Pod
+// Security Standard. An init container moves the Apache configuration to a
+// writable volume and off port 80.
+func hardenWordpressPod(m *examplev1.Wordpress, template *corev1.PodTemplateSpec) {
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name:         apacheConfigVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	template.Spec.InitContainers = append(template.Spec.InitContainers, corev1.Container{
+		Image:        wordpressImage(m),
+		Name:         "apache-config",
+		Command:      []string{"sh", "-c", apacheConfigScript},
+		Resources:    jobResources(),
+		VolumeMounts: []corev1.VolumeMount{{Name: apacheConfigVolume, MountPath: "/apache2"}},
+	})
+	for i := range template.Spec.Containers {
+		c := &template.Spec.Containers[i]
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: apacheConfigVolume, MountPath: "/etc/apache2"})
+	}
+	hardenPod(template, wordpressUser, wordpressUser, wordpressWritableDirs)
+}
+
+// hardenJobPod makes the pods of a Job of m meet the restricted Pod Security
+// Standard, when m asks for it. The Jobs run database clients as the mysql
+// user; group owns the volumes they mount.
+func hardenJobPod(m *examplev1.Wordpress, template *corev1.PodTemplateSpec, group int64) {
+	if restricted(m) {
+		hardenPod(template, databaseUser, group, jobWritableDirs)
+	}
+}
+
+// wordpressPort returns the port WordPress listens on.
+func wordpressPort(m *examplev1.Wordpress) int32 {
+	if restricted(m) {
+		return restrictedWordpressPort
+	}
+	return defaultWordpressPort
+}
+
+// unsupportedImages returns the images of m that are not known to run under
+// the Restricted profile, each with the reason. Hardening relies on the
+// users and the file layout of the official images.
+func unsupportedImages(m *examplev1.Wordpress) []string {
+	var problems []string
+	if repo, tag := splitImage(wordpressImage(m)); !officialImage(repo, "wordpress") {
+		problems = append(problems, fmt.Sprintf("%s is not an official WordPress image", wordpressImage(m)))
+	} else if strings.Contains(tag, "fpm") || strings.Contains(tag, "cli") {
+		problems = append(problems, fmt.Sprintf("%s does not serve WordPress through Apache", wordpressImage(m)))
+	}
+	if repo, _ := splitImage(databaseClientImage(m)); !officialImage(repo, "mysql") && !officialImage(repo, "mariadb") {
+		problems = append(problems, fmt.Sprintf("%s is not an official MySQL or MariaDB image", databaseClientImage(m)))
+	}
+	return problems
+}
+
+// splitImage returns the repository of image and its tag.
+func splitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+// officialImage reports whether repo is the official image name on Docker
+// Hub, or a mirror of it on another registry.
+func officialImage(repo, name string) bool {
+	parts := strings.Split(repo, "/")
+	if parts[len(parts)-1] != name {
+		return false
+	}
+	if len(parts) > 1 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") && parts[0] != "docker.io" && parts[0] != "index.docker.io" {
+		return true
+	}
+	if parts[0] == "docker.io" || parts[0] == "index.docker.io" {
+		parts = parts[1:]
+	}
+	return len(parts) == 1 || (len(parts) == 2 && parts[0] == "library")
+}
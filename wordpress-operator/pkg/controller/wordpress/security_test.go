@@ -0,0 +1,114 @@
+package wordpress
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+func TestReconcileHardensPods(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			SecurityProfile: examplev1.SecurityProfileRestricted,
+			Wordpress:       examplev1.WordpressTierSpec{Image: "wordpress:6.4-fpm"},
+		},
+	}
+	r := newTestReconciler(t, wp)
+
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	sts := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, sts); err != nil {
+		t.Fatal(err)
+	}
+	checkRestricted(t, "mysql", sts.Spec.Template)
+
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	checkRestricted(t, "wordpress", dep.Spec.Template)
+	if port := dep.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort; port != restrictedWordpressPort {
+		t.Errorf("wordpress listens on %d, want %d", port, restrictedWordpressPort)
+	}
+	if len(dep.Spec.Template.Spec.InitContainers) != 1 {
+		t.Errorf("init containers = %+v, want the one moving Apache off port 80", dep.Spec.Template.Spec.InitContainers)
+	}
+
+	for _, job := range []*batchv1.Job{
+		r.databaseUserJobForWordpress(wp),
+		r.passwordJobForWordpress(wp),
+		r.seedJobForWordpress(wp, 1),
+		r.backupJobForWordpress(wp),
+	} {
+		checkRestricted(t, "job "+job.Name, job.Spec.Template)
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	c := meta.FindStatusCondition(got.Status.Conditions, examplev1.ConditionSecurityProfileSupported)
+	if c == nil || c.Status == metav1.ConditionTrue {
+		t.Errorf("condition %s = %+v, want false for an FPM image", examplev1.ConditionSecurityProfileSupported, c)
+	}
+}
+
+func checkRestricted(t *testing.T, tier string, template corev1.PodTemplateSpec) {
+	t.Helper()
+	sc := template.Spec.SecurityContext
+	if sc == nil || sc.SeccompProfile == nil || sc.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("%s pods have no RuntimeDefault seccomp profile", tier)
+	}
+	// Pod Security admission only reads the field.
+	if _, ok := template.Annotations[corev1.SeccompPodAnnotationKey]; ok {
+		t.Errorf("%s pods still carry the deprecated seccomp annotation", tier)
+	}
+	if sc == nil || sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot {
+		t.Errorf("%s pods may run as root", tier)
+	}
+	for _, c := range append(template.Spec.InitContainers, template.Spec.Containers...) {
+		sc := c.SecurityContext
+		if sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem ||
+			sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation ||
+			sc.Capabilities == nil || len(sc.Capabilities.Drop) != 1 || sc.Capabilities.Drop[0] != "ALL" {
+			t.Errorf("%s container %s security context = %+v, want it restricted", tier, c.Name, sc)
+		}
+	}
+}
+
+func TestUnsupportedImages(t *testing.T) {
+	for _, tt := range []struct {
+		wordpress, database string
+		supported           bool
+	}{
+		{"", "", true},
+		{"docker.io/library/wordpress:6.4-apache", "mariadb:10.11", true},
+		{"registry.example.com/mirror/wordpress@sha256:abc", "mysql", true},
+		{"wordpress:php8.2-fpm", "", false},
+		{"bitnami/wordpress:6", "", false},
+		{"", "docker.io/bitnami/mariadb:10.11", false},
+		{"localhost:5000/wordpress:6.4", "", true},
+		{"", "percona:8.0", false},
+	} {
+		wp := &examplev1.Wordpress{Spec: examplev1.WordpressSpec{
+			Wordpress: examplev1.WordpressTierSpec{Image: tt.wordpress},
+			Database:  examplev1.DatabaseSpec{Image: tt.database},
+		}}
+		if problems := unsupportedImages(wp); (len(problems) == 0) != tt.supported {
+			t.Errorf("images %q, %q: problems %v, want supported %v", tt.wordpress, tt.database, problems, tt.supported)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package wordpress
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// updateStatus observes the objects of the site and records their state in
+// the status subresource of m. oldStatus is the status m had before this
+// reconcile, and reconcileErr the error the reconcile ended with, if any.
+func (r *ReconcileWordpress) updateStatus(m *examplev1.Wordpress, oldStatus *examplev1.WordpressStatus, reconcileErr error) error {
+	status := m.Status.DeepCopy()
+	status.ObservedGeneration = m.Generation
+	status.Resources = nil
+
+	external := m.Spec.Database.External != nil
+	mysqlName := fmt.Sprintf("%s-mysql", m.Name)
+	wordpressName := fmt.Sprintf("%s-wordpress", m.Name)
+
+	// There is no MySQL StatefulSet, Service or volume with an external database.
+	claims := []string{wordpressName}
+	services := []string{wordpressName}
+	if !external {
+		dbClaims, err := r.databaseClaimNames(m)
+		if err != nil {
+			return err
+		}
+		claims = append(dbClaims, wordpressName)
+		services = []string{mysqlHeadlessServiceName(m), mysqlName, mysqlReadServiceName(m), wordpressName}
+	}
+
+	sec := &corev1.Secret{}
+	if _, err := r.observe(m, m.Name, sec, status); err != nil {
+		return err
+	}
+
+	// Storage
+	var unbound []string
+	for _, name := range claims {
+		pvc := &corev1.PersistentVolumeClaim{}
+		found, err := r.observe(m, name, pvc, status)
+		if err != nil {
+			return err
+		}
+		if !found || pvc.Status.Phase != corev1.ClaimBound {
+			unbound = append(unbound, name)
+		}
+	}
+	setCondition(status, examplev1.ConditionStorageBound, len(unbound) == 0, "Bound", "NotBound",
+		"PersistentVolumeClaims not bound: "+strings.Join(unbound, ", "))
+
+	// Workloads
+	var rollingOut []string
+	if external {
+		checks := &batchv1.JobList{}
+		err := r.client.List(context.TODO(), checks, client.InNamespace(m.Namespace), client.MatchingLabels(databaseCheckLabels(m.Name)))
+		if err != nil {
+			return err
+		}
+		setCondition(status, examplev1.ConditionDatabaseReady, externalDatabaseReady(checks.Items), "Connected", "NotConnected",
+			fmt.Sprintf("Could not connect to %s yet", externalDatabaseAddress(m.Spec.Database.External)))
+		status.DatabaseImage = ""
+	} else {
+		mysqlSts := &appsv1.StatefulSet{}
+		found, err := r.observe(m, mysqlName, mysqlSts, status)
+		if err != nil {
+			return err
+		}
+		message, err := r.tierUnreadyMessage(m, "mysql", fmt.Sprintf("StatefulSet %s has no ready replicas", mysqlName))
+		if err != nil {
+			return err
+		}
+		setCondition(status, examplev1.ConditionDatabaseReady, found && mysqlSts.Status.ReadyReplicas > 0, "Available", "Unavailable", message)
+		if found && statefulSetRolledOut(mysqlSts) {
+			status.DatabaseImage = containerImage(mysqlSts.Spec.Template, "mysql")
+		} else if found {
+			rollingOut = append(rollingOut, "StatefulSet "+mysqlName)
+		}
+		status.Replicas = nil
+		if found && databaseReplicas(mysqlSts) > 0 {
+			status.Replicas, err = r.observeReplication(m, mysqlSts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(status.Replicas) > 0 {
+		healthy, message := replicationHealthy(status.Replicas)
+		setCondition(status, examplev1.ConditionReplicationHealthy, healthy, "Replicating", "NotReplicating", message)
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, examplev1.ConditionReplicationHealthy)
+	}
+
+	wordpressDep := &appsv1.Deployment{}
+	found, err := r.observe(m, wordpressName, wordpressDep, status)
+	if err != nil {
+		return err
+	}
+	message, err := r.tierUnreadyMessage(m, "frontend", fmt.Sprintf("Deployment %s has no available replicas", wordpressName))
+	if err != nil {
+		return err
+	}
+	setCondition(status, examplev1.ConditionWordPressReady, found && wordpressDep.Status.AvailableReplicas > 0, "Available", "Unavailable", message)
+	if found && deploymentRolledOut(wordpressDep) {
+		status.WordpressImage = containerImage(wordpressDep.Spec.Template, "wordpress")
+	} else if found {
+		rollingOut = append(rollingOut, "Deployment "+wordpressName)
+	}
+	if _, err := r.observe(m, wordpressName, &autoscalingv2.HorizontalPodAutoscaler{}, status); err != nil {
+		return err
+	}
+
+	// Services
+	var missing []string
+	wordpressSvc := &corev1.Service{}
+	for _, name := range services {
+		svc := &corev1.Service{}
+		found, err := r.observe(m, name, svc, status)
+		if err != nil {
+			return err
+		}
+		if !found || svc.Spec.ClusterIP == "" {
+			missing = append(missing, name)
+		}
+		if found && name == wordpressName {
+			wordpressSvc = svc
+		}
+	}
+	ingress := &networkingv1.Ingress{}
+	ingressFound, err := r.observe(m, wordpressName, ingress, status)
+	if err != nil {
+		return err
+	}
+	if m.Spec.Ingress != nil && !ingressFound {
+		missing = append(missing, "Ingress "+wordpressName)
+	}
+	setCondition(status, examplev1.ConditionServiceReady, len(missing) == 0, "Created", "NotCreated",
+		"Services not ready: "+strings.Join(missing, ", "))
+	for _, name := range []string{mysqlName, wordpressName} {
+		if _, err := r.observe(m, name, &networkingv1.NetworkPolicy{}, status); err != nil {
+			return err
+		}
+	}
+
+	// The certificate cert-manager issues for the site
+	if issuerRef(m) != nil {
+		cert := newCertificate()
+		found, err := r.observe(m, wordpressName, cert, status)
+		switch {
+		case meta.IsNoMatchError(err):
+			setCondition(status, examplev1.ConditionCertificateReady, false, "", "CertManagerNotInstalled",
+				"spec.ingress.tls.issuerRef is set, but the cert-manager CRDs are not installed")
+		case err != nil:
+			return err
+		case !found:
+			setCondition(status, examplev1.ConditionCertificateReady, false, "", "NotIssued",
+				fmt.Sprintf("Certificate %s has not been created yet", wordpressName))
+		default:
+			ready, message := certificateReady(cert)
+			setCondition(status, examplev1.ConditionCertificateReady, ready, "Issued", "NotIssued", message)
+		}
+	} else if operatorCAEnabled(m) {
+		sec := &corev1.Secret{}
+		found, err := r.observe(m, tlsSecretName(m), sec, status)
+		if err != nil {
+			return err
+		}
+		issued := false
+		if found {
+			cert, err := parseCertificate(sec.Data[corev1.TLSCertKey])
+			issued = err == nil && time.Now().Before(cert.NotAfter)
+		}
+		setCondition(status, examplev1.ConditionCertificateReady, issued, "Issued", "NotIssued",
+			fmt.Sprintf("Secret %s holds no valid certificate", tlsSecretName(m)))
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, examplev1.ConditionCertificateReady)
+	}
+
+	// Where the site can be reached
+	status.URL = ""
+	if m.Spec.Ingress != nil {
+		status.Endpoints = loadBalancerEndpoints(ingress.Status.LoadBalancer)
+		status.URL = ingressURL(m)
+	} else {
+		status.Endpoints = loadBalancerEndpoints(wordpressSvc.Status.LoadBalancer)
+		if len(status.Endpoints) > 0 {
+			status.URL = "http://" + status.Endpoints[0]
+		}
+	}
+
+	// Security profile
+	if restricted(m) {
+		problems := unsupportedImages(m)
+		setCondition(status, examplev1.ConditionSecurityProfileSupported, len(problems) == 0, "ImagesSupported", "ImageNotSupported",
+			"Not known to run under the Restricted profile: "+strings.Join(problems, "; "))
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, examplev1.ConditionSecurityProfileSupported)
+	}
+
+	// Overall readiness and phase
+	var notReady []string
+	required := []string{
+		examplev1.ConditionStorageBound,
+		examplev1.ConditionDatabaseReady,
+		examplev1.ConditionWordPressReady,
+		examplev1.ConditionServiceReady,
+	}
+	if len(status.Replicas) > 0 {
+		required = append(required, examplev1.ConditionReplicationHealthy)
+	}
+	if issuerRef(m) != nil || operatorCAEnabled(m) {
+		required = append(required, examplev1.ConditionCertificateReady)
+	}
+	for _, t := range required {
+		if !meta.IsStatusConditionTrue(status.Conditions, t) {
+			notReady = append(notReady, t)
+		}
+	}
+	// Only what cannot resolve itself makes the site fail: the spec or the
+	// environment has to change first. Other errors are retried and, like a
+	// rollout, only reported by the Progressing condition. A conflict only
+	// means the reconcile raced with another update.
+	failure, failureMessage := terminalFailure(status, reconcileErr)
+	progressing := metav1.Condition{
+		Type:               examplev1.ConditionProgressing,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: status.ObservedGeneration,
+		Reason:             "UpToDate",
+	}
+	switch {
+	case failure != "":
+		progressing.Reason = failure
+		progressing.Message = failureMessage
+	case reconcileErr != nil && !errors.IsConflict(reconcileErr):
+		progressing.Status = metav1.ConditionTrue
+		progressing.Reason = "ReconcileRetrying"
+		progressing.Message = reconcileErr.Error()
+	case len(rollingOut) > 0:
+		progressing.Status = metav1.ConditionTrue
+		progressing.Reason = "RollingOut"
+		progressing.Message = "Rolling out " + strings.Join(rollingOut, ", ")
+	}
+	meta.SetStatusCondition(&status.Conditions, progressing)
+
+	switch {
+	case failure != "":
+		status.Phase = examplev1.PhaseFailed
+		setCondition(status, examplev1.ConditionReady, false, "", failure, failureMessage)
+	case len(notReady) == 0:
+		status.Phase = examplev1.PhaseReady
+		setCondition(status, examplev1.ConditionReady, true, "AllComponentsReady", "", "")
+	default:
+		status.Phase = examplev1.PhaseProvisioning
+		if oldStatus.Phase == examplev1.PhaseReady || oldStatus.Phase == examplev1.PhaseDegraded {
+			status.Phase = examplev1.PhaseDegraded
+		}
+		setCondition(status, examplev1.ConditionReady, false, "", "ComponentsNotReady",
+			"Not ready: "+strings.Join(notReady, ", "))
+	}
+
+	if reflect.DeepEqual(status, oldStatus) {
+		return nil
+	}
+	m.Status = *status
+	return r.client.Status().Update(context.TODO(), m)
+}
+
+// terminalFailure returns the reason and message of what keeps the site from
+// progressing until its spec or its environment changes: a rejected volume
+// resize, a spec at odds with immutable fields, images the security profile
+// does not support, or a failed Job. It returns an empty reason when there is
+// none; the site is then still progressing, even if reconcileErr is set.
+func terminalFailure(status *examplev1.WordpressStatus, reconcileErr error) (string, string) {
+	if c := meta.FindStatusCondition(status.Conditions, examplev1.ConditionStorageResizeFailed); c != nil && c.Status == metav1.ConditionTrue {
+		return "ResizeRejected", c.Message
+	}
+	if c := meta.FindStatusCondition(status.Conditions, examplev1.ConditionImmutableFieldConflict); c != nil && c.Status == metav1.ConditionTrue {
+		return "ImmutableFieldConflict", c.Message
+	}
+	if c := meta.FindStatusCondition(status.Conditions, examplev1.ConditionSecurityProfileSupported); c != nil && c.Status == metav1.ConditionFalse {
+		return "ImageNotSupported", c.Message
+	}
+	if jobFailed(reconcileErr) {
+		return "JobFailed", reconcileErr.Error()
+	}
+	return "", ""
+}
+
+// observe fetches the named child of m into obj and, when it exists, adds a
+// reference to it to status. It reports whether the child exists.
+func (r *ReconcileWordpress) observe(m *examplev1.Wordpress, name string, obj client.Object, status *examplev1.WordpressStatus) (bool, error) {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: m.Namespace}, obj)
+	if errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, r.scheme)
+	if err != nil {
+		return false, err
+	}
+	status.Resources = append(status.Resources, examplev1.ResourceReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Name:       name,
+	})
+	return true, nil
+}
+
+// loadBalancerEndpoints returns the addresses a load balancer was given, for a
+// LoadBalancer Service or an Ingress.
+func loadBalancerEndpoints(lb corev1.LoadBalancerStatus) []string {
+	var endpoints []string
+	for _, ing := range lb.Ingress {
+		if ing.Hostname != "" {
+			endpoints = append(endpoints, ing.Hostname)
+		} else if ing.IP != "" {
+			endpoints = append(endpoints, ing.IP)
+		}
+	}
+	return endpoints
+}
+
+// tierUnreadyMessage returns message, followed by the pods of the tier of m
+// that fail their probes.
+func (r *ReconcileWordpress) tierUnreadyMessage(m *examplev1.Wordpress, tier, message string) (string, error) {
+	unready, err := r.unreadyPods(m, tier)
+	if err != nil || len(unready) == 0 {
+		return message, err
+	}
+	return fmt.Sprintf("%s; pods not ready: %s", message, strings.Join(unready, ", ")), nil
+}
+
+// setCondition sets condition t to True with reason trueReason when ok holds,
+// and to False with falseReason and message otherwise.
+func setCondition(status *examplev1.WordpressStatus, t string, ok bool, trueReason, falseReason, message string) {
+	c := metav1.Condition{
+		Type:               t,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: status.ObservedGeneration,
+		Reason:             trueReason,
+	}
+	if !ok {
+		c.Status = metav1.ConditionFalse
+		c.Reason = falseReason
+		c.Message = message
+	}
+	meta.SetStatusCondition(&status.Conditions, c)
+}
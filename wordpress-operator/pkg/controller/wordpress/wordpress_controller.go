@@ -2,19 +2,27 @@ package wordpress
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
+	"time"
 
+	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+	"github.com/renan-campos/wordpress-operator/pkg/redact"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -24,17 +32,61 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-var log = logf.Log.WithName("controller_wordpress")
+// Secrets and passwords pass through this controller, so its logger redacts them.
+var log = redact.NewLogger(logf.Log.WithName("controller_wordpress"))
+
+const (
+	// Image used when the Wordpress spec does not name one. The database
+	// images are chosen by engine.
+	defaultWordpressImage = "wordpress:6.8-apache"
+
+	// mysqlDataVolume names the volume with the data directory of the
+	// database, and the volumeClaimTemplate it is claimed through.
+	mysqlDataVolume = "data"
+
+	// Size of a tier's volume when the Wordpress spec does not set one.
+	defaultVolumeSize = 20 * 1024 * 1024 * 1024
+
+	// How long to wait before checking on something that is still in progress.
+	rolloutPollInterval = 10 * time.Second
+	// How often the health of read replicas is checked. Replication does not
+	// change any object the controller watches.
+	replicationPollInterval = time.Minute
+)
 
 // Add creates a new Wordpress Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	r, err := newReconciler(mgr)
+	if err != nil {
+		return err
+	}
+	return add(mgr, r)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileWordpress{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+func newReconciler(mgr manager.Manager) (reconcile.Reconciler, error) {
+	exec, err := newRemoteExecutor(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	size, err := operatorDefaultSize()
+	if err != nil {
+		return nil, err
+	}
+	noNetworkPolicies, err := operatorNetworkPoliciesDisabled()
+	if err != nil {
+		return nil, err
+	}
+	return &ReconcileWordpress{
+		client:                  mgr.GetClient(),
+		scheme:                  mgr.GetScheme(),
+		exec:                    exec,
+		caNamespace:             operatorCANamespace(),
+		defaultSize:             size,
+		networkPoliciesDisabled: noNetworkPolicies,
+		ingressNamespace:        operatorIngressNamespace(),
+	}, nil
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -59,6 +111,14 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	if err != nil {
 		return err
 	}
+	// Watch the Secrets referenced by spec.database.passwordSecretRef; they are not owned by the Wordpress.
+	mapSecret := func(a client.Object) []reconcile.Request {
+		return requestsForSecret(mgr.GetClient(), a)
+	}
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(mapSecret))
+	if err != nil {
+		return err
+	}
 	err = c.Watch(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &examplev1.Wordpress{},
@@ -73,6 +133,20 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	if err != nil {
 		return err
 	}
+	err = c.Watch(&source.Kind{Type: &appsv1.StatefulSet{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &examplev1.Wordpress{},
+	})
+	if err != nil {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &autoscalingv2.HorizontalPodAutoscaler{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &examplev1.Wordpress{},
+	})
+	if err != nil {
+		return err
+	}
 	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &examplev1.Wordpress{},
@@ -80,6 +154,43 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	if err != nil {
 		return err
 	}
+	err = c.Watch(&source.Kind{Type: &networkingv1.Ingress{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &examplev1.Wordpress{},
+	})
+	if err != nil {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &networkingv1.NetworkPolicy{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &examplev1.Wordpress{},
+	})
+	if err != nil {
+		return err
+	}
+	// cert-manager is optional. Without its CRDs there are no Certificates to
+	// watch, and sites that ask for one report that it cannot be issued.
+	_, err = mgr.GetRESTMapper().RESTMapping(certificateGVK.GroupKind(), certificateGVK.Version)
+	if err == nil {
+		err = c.Watch(&source.Kind{Type: newCertificate()}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &examplev1.Wordpress{},
+		})
+		if err != nil {
+			return err
+		}
+	} else if meta.IsNoMatchError(err) {
+		log.Info("Install cert-manager in your cluster to issue certificates for Wordpress sites", "error", err.Error())
+	} else {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &examplev1.Wordpress{},
+	})
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -93,13 +204,26 @@ type ReconcileWordpress struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	// exec runs commands in the database pods, to ask the replicas how
+	// replication is going.
+	exec podExecutor
+	// caNamespace is where the root of the operator's CA is kept.
+	caNamespace string
+	// defaultSize is the size profile of sites that do not choose one.
+	defaultSize examplev1.SizeProfile
+	// networkPoliciesDisabled leaves out the NetworkPolicies of every site,
+	// for clusters whose network plugin does not enforce them.
+	networkPoliciesDisabled bool
+	// ingressNamespace is the namespace of the ingress controller, which may
+	// reach WordPress by default.
+	ingressNamespace string
 }
 
 // Reconcile reads that state of the cluster for a Wordpress object and makes changes based on the state read
 // and what is in the Wordpress.Spec
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
-func (r *ReconcileWordpress) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+func (r *ReconcileWordpress) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
 	reqLogger.Info("Reconciling Wordpress")
 
@@ -109,7 +233,7 @@ func (r *ReconcileWordpress) Reconcile(request reconcile.Request) (reconcile.Res
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
-			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Owned objects are automatically garbage collected once the deletion policy is carried out.
 			// Return and don't requeue
 			return reconcile.Result{}, nil
 		}
@@ -117,197 +241,162 @@ func (r *ReconcileWordpress) Reconcile(request reconcile.Request) (reconcile.Res
 		return reconcile.Result{}, err
 	}
 
-	/***
-	NOTE: Is this pattern of doing an action+requeue better than doing all the actions at once?
-	      This is what the tutorial did, so I'll stick with it for now.
-	  		TODO: Will ask about doing the actions all at once later.
-	NOTE: All of these steps could be completed with a call to a higher-order function.
-	      This will greatly reduce the amount of code and improve the clarity.
-				I will do so once I study higher-order functions in Go.
-	***/
-	// Create Secret if it doesn't already exist
-	secretFound := &corev1.Secret{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, secretFound)
-	if err != nil && errors.IsNotFound(err) {
-		sec := r.secretForWordpress(instance)
-		reqLogger.Info("Creating a new Secret", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name)
-		err = r.client.Create(context.TODO(), sec)
-		if err != nil {
-			reqLogger.Error(err, "Failed to create new Secret", "Secret.Namespace", sec.Namespace, "Secret.Name", sec.Name)
-			return reconcile.Result{}, err
-		}
-		// Secret created successfully - return and requeue
-		return reconcile.Result{Requeue: true}, nil
-	} else if err != nil {
-		reqLogger.Error(err, "Failed to get Secret")
+	// Carry out the deletion policy before the garbage collector deletes the
+	// objects of the site.
+	if instance.GetDeletionTimestamp() != nil {
+		return r.finalizeWordpress(instance, reqLogger)
+	}
+	if err := r.ensureFinalizer(instance, reqLogger); err != nil {
+		reqLogger.Error(err, "Failed to add finalizer")
 		return reconcile.Result{}, err
 	}
 
-	// Names used for other secondary resources.
-	mysqlName := fmt.Sprintf("%s-mysql", instance.Name)
-	wordpressName := fmt.Sprintf("%s-wordpress", instance.Name)
+	oldStatus := instance.Status.DeepCopy()
+	result, err := r.reconcileWordpress(instance, reqLogger)
 
-	// Create mysql PersistentVolumeClaim if it doesn't already exist.
-	mysqlPVCFound := &corev1.PersistentVolumeClaim{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: mysqlName, Namespace: instance.Namespace}, mysqlPVCFound)
-	if err != nil && errors.IsNotFound(err) {
-		mysqlPVC := r.mysqlPVCForWordpress(instance)
-		reqLogger.Info("Creating a new PVC", "mysqlPVC.Namespace", mysqlPVC.Namespace, "mysqlPVC.Name", mysqlPVC.Name)
-		err = r.client.Create(context.TODO(), mysqlPVC)
-		if err != nil {
-			reqLogger.Error(err, "Failed to create new PVC", "mysqlPVC.Namespace", mysqlPVC.Namespace, "mysqlPVC.Name", mysqlPVC.Name)
-			return reconcile.Result{}, err
+	// Report what the cluster looks like now, including any error.
+	if statusErr := r.updateStatus(instance, oldStatus, err); statusErr != nil {
+		reqLogger.Error(statusErr, "Failed to update Wordpress status")
+		if err == nil {
+			return reconcile.Result{}, statusErr
 		}
-		// PVC created successfully - return and requeue
-		return reconcile.Result{Requeue: true}, nil
-	} else if err != nil {
-		reqLogger.Error(err, "Failed to get mysql PVC")
-		return reconcile.Result{}, err
 	}
-	// Create wordpress PVC if it doesn't already exist.
-	wordpressPVCFound := &corev1.PersistentVolumeClaim{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: wordpressName, Namespace: instance.Namespace}, wordpressPVCFound)
-	if err != nil && errors.IsNotFound(err) {
-		wordpressPVC := r.wordpressPVCForWordpress(instance)
-		reqLogger.Info("Creating a new PVC", "wordpressPVC.Namespace", wordpressPVC.Namespace, "wordpressPVC.Name", wordpressPVC.Name)
-		err = r.client.Create(context.TODO(), wordpressPVC)
-		if err != nil {
-			reqLogger.Error(err, "Failed to create new PVC", "wordpressPVC.Namespace", wordpressPVC.Namespace, "wordpressPVC.Name", wordpressPVC.Name)
-			return reconcile.Result{}, err
-		}
-		// PVC created successfully - return and requeue
-		return reconcile.Result{Requeue: true}, nil
-	} else if err != nil {
-		reqLogger.Error(err, "Failed to get wordpress PVC")
-		return reconcile.Result{}, err
+	return result, err
+}
+
+// reconcileWordpress creates and updates the objects that make up the site in
+// a single pass, in dependency order. A component that fails does not stop the
+// ones that do not depend on it; the errors are collected and returned together.
+// The request is only requeued while something is pending, such as MySQL
+// becoming available.
+func (r *ReconcileWordpress) reconcileWordpress(instance *examplev1.Wordpress, reqLogger logr.Logger) (reconcile.Result, error) {
+	var errs []error
+	pending := false
+	external := instance.Spec.Database.External != nil
+
+	// Nothing may be rolled out or migrated before the running images are known.
+	if err := r.recordRunningImages(instance, reqLogger); err != nil {
+		return reconcile.Result{}, fmt.Errorf("images: %w", err)
 	}
 
-	// Create mysql deployment if it doesn't already exist.
-	mysqlDepFound := &appsv1.Deployment{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: mysqlName, Namespace: instance.Namespace}, mysqlDepFound)
-	if err != nil && errors.IsNotFound(err) {
-		mysqlDep := r.mysqlDeploymentForWordpress(instance)
-		reqLogger.Info("Creating a new Deployment", "mysqlDep.Namespace", mysqlDep.Namespace, "mysqlDep.Name", mysqlDep.Name)
-		err = r.client.Create(context.TODO(), mysqlDep)
+	// Every Deployment reads its credentials from the Secret, so nothing can
+	// be set up without it. An external database comes with its own.
+	var secret *corev1.Secret
+	if !external {
+		var err error
+		secret, err = r.ensureSecret(instance, reqLogger)
 		if err != nil {
-			reqLogger.Error(err, "Failed to create new Deployment", "mysqlDep.Namespace", mysqlDep.Namespace, "mysqlDep.Name", mysqlDep.Name)
-			return reconcile.Result{}, err
+			return reconcile.Result{}, fmt.Errorf("secret: %w", err)
 		}
-		// Deployment created successfully - return and requeue
-		return reconcile.Result{Requeue: true}, nil
-	} else if err != nil {
-		reqLogger.Error(err, "Failed to get mysql Deployment")
-		return reconcile.Result{}, err
 	}
-	// Create mysql service
-	mysqlServiceFound := &corev1.Service{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: mysqlName, Namespace: instance.Namespace}, mysqlServiceFound)
-	if err != nil && errors.IsNotFound(err) {
-		mysqlService := r.mysqlServiceForWordpress(instance)
-		reqLogger.Info("Creating a new Service", "mysqlService.Namespace", mysqlService.Namespace, "mysqlService.Name", mysqlService.Name)
-		err = r.client.Create(context.TODO(), mysqlService)
-		if err != nil {
-			reqLogger.Error(err, "Failed to create new Service", "mysqlService.Namespace", mysqlService.Namespace, "mysqlService.Name", mysqlService.Name)
-			return reconcile.Result{}, err
-		}
-		// Service created successfully - return and requeue
-		return reconcile.Result{Requeue: true}, nil
-	} else if err != nil {
-		reqLogger.Error(err, "Failed to get mysql Service")
-		return reconcile.Result{}, err
+
+	if err := r.ensureStorage(instance, reqLogger); err != nil {
+		errs = append(errs, fmt.Errorf("storage: %w", err))
 	}
 
-	// Create wordpress deployment if it doesn't already exist.
-	wordpressDepFound := &appsv1.Deployment{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: wordpressName, Namespace: instance.Namespace}, wordpressDepFound)
-	if err != nil && errors.IsNotFound(err) {
-		wordpressDep := r.wordpressDeploymentForWordpress(instance)
-		reqLogger.Info("Creating a new Deployment", "wordpressDep.Namespace", wordpressDep.Namespace, "wordpressDep.Name", wordpressDep.Name)
-		err = r.client.Create(context.TODO(), wordpressDep)
+	// The database is isolated before it starts.
+	if err := r.ensureNetworkPolicies(instance, reqLogger); err != nil {
+		errs = append(errs, fmt.Errorf("network policies: %w", err))
+	}
+
+	var mysqlSts *appsv1.StatefulSet
+	userReady := true
+	if external {
+		// The site is only ready once WordPress can reach its database.
+		ready, err := r.verifyExternalDatabase(instance, reqLogger)
 		if err != nil {
-			reqLogger.Error(err, "Failed to create new Deployment", "wordpressDep.Namespace", wordpressDep.Namespace, "wordpressDep.Name", wordpressDep.Name)
-			return reconcile.Result{}, err
+			errs = append(errs, fmt.Errorf("external database: %w", err))
+		} else if !ready {
+			reqLogger.Info("Waiting for the external database check")
+			pending = true
 		}
-		// Deployment created successfully - return and requeue
-		return reconcile.Result{Requeue: true}, nil
-	} else if err != nil {
-		reqLogger.Error(err, "Failed to get wordpress Deployment")
-		return reconcile.Result{}, err
-	}
-	// Create wordpress service
-	wordpressServiceFound := &corev1.Service{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: wordpressName, Namespace: instance.Namespace}, wordpressServiceFound)
-	if err != nil && errors.IsNotFound(err) {
-		wordpressService := r.wordpressServiceForWordpress(instance)
-		reqLogger.Info("Creating a new Service", "wordpressService.Namespace", wordpressService.Namespace, "wordpressService.Name", wordpressService.Name)
-		err = r.client.Create(context.TODO(), wordpressService)
+	} else {
+		var err error
+		mysqlSts, err = r.ensureDatabase(instance, reqLogger)
 		if err != nil {
-			reqLogger.Error(err, "Failed to create new Service", "wordpressService.Namespace", wordpressService.Namespace, "wordpressService.Name", wordpressService.Name)
-			return reconcile.Result{}, err
+			errs = append(errs, fmt.Errorf("database: %w", err))
+		} else if mysqlSts == nil || mysqlSts.Status.ReadyReplicas == 0 {
+			reqLogger.Info("Waiting for mysql to become available")
+			pending = true
 		}
-		// Service created successfully - return and requeue
-		return reconcile.Result{Requeue: true}, nil
-	} else if err != nil {
-		reqLogger.Error(err, "Failed to get wordpress Service")
-		return reconcile.Result{}, err
-	}
-
-	// My ultra secure operator ;)
-	// Probably a better way to do this, if I actually knew Go.
-	s := fmt.Sprintf("Database password: %s", instance.Spec.Password)
-	reqLogger.Info(s)
 
-	return reconcile.Result{}, nil
-
-}
-
-func (r *ReconcileWordpress) secretForWordpress(m *examplev1.Wordpress) *corev1.Secret {
-	ls := labelsForWordpress(m.Name)
+		// Apply a changed root password to MySQL before it is used again.
+		_, rotating := secret.Data[secretPreviousRootPasswordKey]
+		if rotating && err == nil {
+			done, err := r.rotateRootPassword(instance, secret, reqLogger)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("password change: %w", err))
+			} else if !done {
+				pending = true
+			} else {
+				rotating = false
+			}
+		}
 
-	pw := []byte(m.Spec.Password)
-	enc_pw := make([]byte, base64.StdEncoding.EncodedLen(len(pw)))
+		// The application user is created with the root password.
+		userReady = false
+		if !rotating && err == nil {
+			userReady, err = r.ensureDatabaseUser(instance, reqLogger)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("database user: %w", err))
+			} else if !userReady {
+				pending = true
+			}
+		}
 
-	base64.StdEncoding.Encode(enc_pw, pw)
+		// Replicas are seeded once the primary holds everything WordPress needs.
+		if userReady && mysqlSts != nil {
+			seeded, err := r.ensureReplicas(instance, mysqlSts, reqLogger)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("replicas: %w", err))
+			} else if !seeded {
+				reqLogger.Info("Waiting for read replicas to be seeded")
+				pending = true
+			}
+		}
+	}
 
-	sec := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name,
-			Namespace: m.Namespace,
-			Labels:    ls,
-		},
-		Data: map[string][]byte{"password": enc_pw},
+	waiting, err := r.ensureWordpress(instance, mysqlSts, userReady, reqLogger)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("wordpress: %w", err))
 	}
+	pending = pending || waiting
 
-	// Set Wordpress instance as the owner and controller
-	controllerutil.SetControllerReference(m, sec, r.scheme)
+	// Certificates of the operator's CA are renewed ahead of their expiry.
+	renewIn, err := r.ensureOperatorCertificate(instance, reqLogger)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("certificate: %w", err))
+	}
 
-	return sec
+	if len(errs) > 0 {
+		return reconcile.Result{}, utilerrors.NewAggregate(errs)
+	}
+	if pending {
+		return reconcile.Result{RequeueAfter: rolloutPollInterval}, nil
+	}
+	var requeueAfter time.Duration
+	if !external && instance.Spec.Database.Replicas > 0 {
+		requeueAfter = replicationPollInterval
+	}
+	if renewIn > 0 && (requeueAfter == 0 || renewIn < requeueAfter) {
+		requeueAfter = renewIn
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
 }
 
-func (r *ReconcileWordpress) mysqlPVCForWordpress(m *examplev1.Wordpress) *corev1.PersistentVolumeClaim {
+// mysqlPVCForWordpress returns the claim holding the database of m, named
+// name; see databaseClaimName.
+func (r *ReconcileWordpress) mysqlPVCForWordpress(m *examplev1.Wordpress, name string) *corev1.PersistentVolumeClaim {
 	ls := labelsForWordpress(m.Name)
 	ls["tier"] = "mysql"
 
-	pvc_name := fmt.Sprintf("%s-mysql", m.Name)
-	pvc_size := resource.NewQuantity(20*1024*1024*1024, resource.BinarySI)
-
-	scn := "standard"
-
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pvc_name,
+			Name:      name,
 			Namespace: m.Namespace,
 			Labels:    ls,
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-			StorageClassName: &scn,
-			Resources: corev1.ResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: *pvc_size,
-				},
-			},
-		},
+		Spec: pvcSpecForVolume(m.Spec.Storage.Database),
 	}
 
 	controllerutil.SetControllerReference(m, pvc, r.scheme)
@@ -315,30 +404,23 @@ func (r *ReconcileWordpress) mysqlPVCForWordpress(m *examplev1.Wordpress) *corev
 	return pvc
 }
 
+// wordpressPVCForWordpress returns the claim holding the WordPress files of m.
+// A site that asks for more than one WordPress pod gets a claim they can all
+// mount, unless the spec names the access modes itself.
 func (r *ReconcileWordpress) wordpressPVCForWordpress(m *examplev1.Wordpress) *corev1.PersistentVolumeClaim {
 	ls := labelsForWordpress(m.Name)
 	ls["tier"] = "frontend"
 
-	scn := "standard"
-
-	pvc_name := fmt.Sprintf("%s-wordpress", m.Name)
-	pvc_size := resource.NewQuantity(20*1024*1024*1024, resource.BinarySI)
-
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pvc_name,
+			Name:      fmt.Sprintf("%s-wordpress", m.Name),
 			Namespace: m.Namespace,
 			Labels:    ls,
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-			StorageClassName: &scn,
-			Resources: corev1.ResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: *pvc_size,
-				},
-			},
-		},
+		Spec: pvcSpecForVolume(m.Spec.Storage.Wordpress),
+	}
+	if len(m.Spec.Storage.Wordpress.AccessModes) == 0 && wordpressMaxReplicas(m) > 1 {
+		pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
 	}
 
 	controllerutil.SetControllerReference(m, pvc, r.scheme)
@@ -346,65 +428,131 @@ func (r *ReconcileWordpress) wordpressPVCForWordpress(m *examplev1.Wordpress) *c
 	return pvc
 }
 
-func (r *ReconcileWordpress) mysqlDeploymentForWordpress(m *examplev1.Wordpress) *appsv1.Deployment {
+// pvcSpecForVolume fills in the defaults for a tier's volume.
+// A nil StorageClassName leaves the choice to the cluster default StorageClass.
+func pvcSpecForVolume(v examplev1.VolumeSpec) corev1.PersistentVolumeClaimSpec {
+	size := resource.NewQuantity(defaultVolumeSize, resource.BinarySI)
+	if v.Size != nil {
+		size = v.Size
+	}
+
+	accessModes := v.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	return corev1.PersistentVolumeClaimSpec{
+		AccessModes:      accessModes,
+		StorageClassName: v.StorageClassName,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: *size,
+			},
+		},
+	}
+}
+
+// mysqlStatefulSetForWordpress returns the StatefulSet running the database of
+// m. A StatefulSet stops the old pod before it starts the new one, so two
+// mysqld processes never share the data directory, and its pod keeps its
+// volume wherever it is scheduled. The volume is claimed through a
+// volumeClaimTemplate, unless legacyClaim names the claim of a site created
+// before the database ran as a StatefulSet.
+// The first pod runs the primary and the others its read replicas. A single
+// legacy claim cannot hold the data of more than one server, so such sites
+// run the primary only.
+func (r *ReconcileWordpress) mysqlStatefulSetForWordpress(m *examplev1.Wordpress, legacyClaim string) *appsv1.StatefulSet {
 	ls := labelsForWordpress(m.Name)
 	ls["tier"] = "mysql"
 
-	volName := fmt.Sprintf("%s-mysql", m.Name)
+	name := fmt.Sprintf("%s-mysql", m.Name)
+	engine := engineForWordpress(m)
+	probes := databaseProbes(m)
+	replicas := int32(1)
+	if legacyClaim == "" {
+		replicas += m.Spec.Database.Replicas
+	}
 
-	dep := &appsv1.Deployment{
+	sts := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-mysql", m.Name),
+			Name:      name,
 			Namespace: m.Namespace,
+			Labels:    ls,
 		},
-		Spec: appsv1.DeploymentSpec{
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: mysqlHeadlessServiceName(m),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: ls,
 			},
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: ls,
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
-						Image: "mysql:5.6",
+						Image: mysqlImage(m),
 						Name:  "mysql",
-						Env: []corev1.EnvVar{{
-							Name: "MYSQL_ROOT_PASSWORD",
-							ValueFrom: &corev1.EnvVarSource{
-								SecretKeyRef: &corev1.SecretKeySelector{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: m.Name,
-									},
-									Key: "password",
-								},
-							},
-						}},
+						Env: []corev1.EnvVar{
+							secretEnvVar(engine.envPrefix+"ROOT_PASSWORD", m.Name, secretRootPasswordKey),
+							// Only used when the server initializes an empty data directory.
+							secretEnvVar(engine.envPrefix+"DATABASE", m.Name, secretDatabaseKey),
+							secretEnvVar(engine.envPrefix+"USER", m.Name, secretUsernameKey),
+							secretEnvVar(engine.envPrefix+"PASSWORD", m.Name, secretAppPasswordKey),
+						},
 						Ports: []corev1.ContainerPort{{
 							ContainerPort: 3306,
 							Name:          "mysql",
+							Protocol:      corev1.ProtocolTCP,
 						}},
+						Resources:      r.databaseResources(m),
+						StartupProbe:   probes.startup,
+						LivenessProbe:  probes.liveness,
+						ReadinessProbe: probes.readiness,
 						VolumeMounts: []corev1.VolumeMount{{
-							Name:      volName,
-							MountPath: "/var/lib/mysql",
+							Name:      mysqlDataVolume,
+							MountPath: engine.dataDir,
 						}},
 					}},
-					Volumes: []corev1.Volume{{
-						Name: volName,
-						VolumeSource: corev1.VolumeSource{
-							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-								ClaimName: volName,
-							},
-						},
-					}},
 				},
 			},
 		},
 	}
+	applyScheduling(&sts.Spec.Template.Spec, m.Spec.Database.SchedulingSpec)
+	if restricted(m) {
+		hardenPod(&sts.Spec.Template, databaseUser, databaseUser, databaseWritableDirs)
+	}
 
-	controllerutil.SetControllerReference(m, dep, r.scheme)
+	if m.Spec.Database.Replicas > 0 {
+		sts.Spec.Template.Spec.Containers[0].Command = []string{"sh", "-c", replicationServerScript, "sh", engine.server}
+	}
 
-	return dep
+	if legacyClaim != "" {
+		sts.Spec.Template.Spec.Volumes = []corev1.Volume{{
+			Name: mysqlDataVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: legacyClaim,
+				},
+			},
+		}}
+	} else {
+		pvc := r.mysqlPVCForWordpress(m, mysqlDataVolume)
+		sts.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   pvc.Name,
+				Labels: pvc.Labels,
+			},
+			Spec: pvc.Spec,
+		}}
+	}
+
+	controllerutil.SetControllerReference(m, sts, r.scheme)
+
+	return sts
 }
 
 func (r *ReconcileWordpress) wordpressDeploymentForWordpress(m *examplev1.Wordpress) *appsv1.Deployment {
@@ -412,13 +560,16 @@ func (r *ReconcileWordpress) wordpressDeploymentForWordpress(m *examplev1.Wordpr
 	ls["tier"] = "frontend"
 
 	volName := fmt.Sprintf("%s-wordpress", m.Name)
+	probes := wordpressProbes(m)
 
 	dep := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-wordpress", m.Name),
 			Namespace: m.Namespace,
+			Labels:    ls,
 		},
 		Spec: appsv1.DeploymentSpec{
+			Replicas: wordpressReplicas(m),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: ls,
 			},
@@ -428,27 +579,18 @@ func (r *ReconcileWordpress) wordpressDeploymentForWordpress(m *examplev1.Wordpr
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
-						Image: "wordpress:4.8-apache",
+						Image: wordpressImage(m),
 						Name:  "wordpress",
-						Env: []corev1.EnvVar{{
-							Name: "WORDPRESS_DB_PASSWORD",
-							ValueFrom: &corev1.EnvVarSource{
-								SecretKeyRef: &corev1.SecretKeySelector{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: m.Name,
-									},
-									Key: "password",
-								},
-							},
-						},
-							{
-								Name:  "WORDPRESS_DB_HOST",
-								Value: fmt.Sprintf("%s-mysql", m.Name),
-							}},
+						Env:   wordpressDatabaseEnv(m),
 						Ports: []corev1.ContainerPort{{
-							ContainerPort: 80,
+							ContainerPort: wordpressPort(m),
 							Name:          "wordpress",
+							Protocol:      corev1.ProtocolTCP,
 						}},
+						Resources:      r.wordpressResources(m),
+						StartupProbe:   probes.startup,
+						LivenessProbe:  probes.liveness,
+						ReadinessProbe: probes.readiness,
 						VolumeMounts: []corev1.VolumeMount{{
 							Name:      volName,
 							MountPath: "/var/www/html",
@@ -466,24 +608,101 @@ func (r *ReconcileWordpress) wordpressDeploymentForWordpress(m *examplev1.Wordpr
 			},
 		},
 	}
+	applyScheduling(&dep.Spec.Template.Spec, wordpressScheduling(m))
+	if restricted(m) {
+		hardenWordpressPod(m, &dep.Spec.Template)
+	}
 
 	controllerutil.SetControllerReference(m, dep, r.scheme)
 
 	return dep
 }
 
+// wordpressDatabaseEnv returns the environment WordPress reads its database
+// connection from.
+func wordpressDatabaseEnv(m *examplev1.Wordpress) []corev1.EnvVar {
+	if ext := m.Spec.Database.External; ext != nil {
+		return []corev1.EnvVar{
+			{
+				Name:  "WORDPRESS_DB_NAME",
+				Value: externalDatabaseName(ext),
+			},
+			{
+				Name:  "WORDPRESS_DB_USER",
+				Value: ext.User,
+			},
+			{
+				Name: "WORDPRESS_DB_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: ext.CredentialsSecretRef.DeepCopy(),
+				},
+			},
+			{
+				Name:  "WORDPRESS_DB_HOST",
+				Value: externalDatabaseAddress(ext),
+			},
+		}
+	}
+	return []corev1.EnvVar{
+		secretEnvVar("WORDPRESS_DB_NAME", m.Name, secretDatabaseKey),
+		secretEnvVar("WORDPRESS_DB_USER", m.Name, secretUsernameKey),
+		secretEnvVar("WORDPRESS_DB_PASSWORD", m.Name, secretAppPasswordKey),
+		{
+			Name:  "WORDPRESS_DB_HOST",
+			Value: fmt.Sprintf("%s-mysql", m.Name),
+		},
+	}
+}
+
+// mysqlServiceForWordpress returns the Service through which the database of
+// m is written. It only selects the primary.
 func (r *ReconcileWordpress) mysqlServiceForWordpress(m *examplev1.Wordpress) *corev1.Service {
 	ls := labelsForWordpress(m.Name)
 	ls["tier"] = "mysql"
 
+	selector := labelsForWordpress(m.Name)
+	selector["tier"] = "mysql"
+	selector[appsv1.StatefulSetPodNameLabel] = primaryPodName(m)
+
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-mysql", m.Name),
 			Namespace: m.Namespace,
+			Labels:    ls,
 		},
 		Spec: corev1.ServiceSpec{
 			Ports: []corev1.ServicePort{{
-				Port: 3306,
+				Port:       3306,
+				Protocol:   corev1.ProtocolTCP,
+				TargetPort: intstr.FromInt(3306),
+			}},
+			Selector: selector,
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	controllerutil.SetControllerReference(m, svc, r.scheme)
+
+	return svc
+}
+
+// mysqlReadServiceForWordpress returns the Service through which the database
+// of m is read. It balances over the primary and its read replicas.
+func (r *ReconcileWordpress) mysqlReadServiceForWordpress(m *examplev1.Wordpress) *corev1.Service {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "mysql"
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mysqlReadServiceName(m),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{
+				Port:       3306,
+				Protocol:   corev1.ProtocolTCP,
+				TargetPort: intstr.FromInt(3306),
 			}},
 			Selector: ls,
 			Type:     corev1.ServiceTypeClusterIP,
@@ -495,6 +714,43 @@ func (r *ReconcileWordpress) mysqlServiceForWordpress(m *examplev1.Wordpress) *c
 	return svc
 }
 
+func mysqlReadServiceName(m *examplev1.Wordpress) string {
+	return fmt.Sprintf("%s-mysql-read", m.Name)
+}
+
+// mysqlHeadlessServiceForWordpress returns the Service that gives the pods of
+// the database StatefulSet of m their stable network identity.
+func (r *ReconcileWordpress) mysqlHeadlessServiceForWordpress(m *examplev1.Wordpress) *corev1.Service {
+	ls := labelsForWordpress(m.Name)
+	ls["tier"] = "mysql"
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mysqlHeadlessServiceName(m),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports: []corev1.ServicePort{{
+				Name:       "mysql",
+				Port:       3306,
+				Protocol:   corev1.ProtocolTCP,
+				TargetPort: intstr.FromInt(3306),
+			}},
+			Selector: ls,
+		},
+	}
+
+	controllerutil.SetControllerReference(m, svc, r.scheme)
+
+	return svc
+}
+
+func mysqlHeadlessServiceName(m *examplev1.Wordpress) string {
+	return fmt.Sprintf("%s-mysql-headless", m.Name)
+}
+
 func (r *ReconcileWordpress) wordpressServiceForWordpress(m *examplev1.Wordpress) *corev1.Service {
 	ls := labelsForWordpress(m.Name)
 	ls["tier"] = "frontend"
@@ -503,21 +759,137 @@ func (r *ReconcileWordpress) wordpressServiceForWordpress(m *examplev1.Wordpress
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-wordpress", m.Name),
 			Namespace: m.Namespace,
+			Labels:    ls,
 		},
 		Spec: corev1.ServiceSpec{
 			Ports: []corev1.ServicePort{{
-				Port: 80,
+				Port:       80,
+				Protocol:   corev1.ProtocolTCP,
+				TargetPort: intstr.FromString("wordpress"),
 			}},
 			Selector: ls,
-			Type:     corev1.ServiceTypeLoadBalancer,
+			Type:     wordpressServiceType(m),
 		},
 	}
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		svc.Spec.LoadBalancerSourceRanges = m.Spec.Wordpress.LoadBalancerSourceRanges
+	}
 
 	controllerutil.SetControllerReference(m, svc, r.scheme)
 
 	return svc
 }
 
+// containerImage returns the image of the named container in the pod template t.
+func containerImage(t corev1.PodTemplateSpec, name string) string {
+	for _, c := range t.Spec.Containers {
+		if c.Name == name {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// statefulSetRolledOut reports whether every replica of sts runs the current
+// pod template and is ready.
+func statefulSetRolledOut(sts *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return sts.Status.ObservedGeneration >= sts.Generation &&
+		sts.Status.CurrentRevision == sts.Status.UpdateRevision &&
+		sts.Status.ReadyReplicas == replicas
+}
+
+// deploymentRolledOut reports whether every replica of dep runs the current pod template.
+func deploymentRolledOut(dep *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.ObservedGeneration >= dep.Generation &&
+		dep.Status.UpdatedReplicas == replicas &&
+		dep.Status.Replicas == replicas &&
+		dep.Status.AvailableReplicas == replicas
+}
+
+// wordpressImage returns the WordPress image of m. A site that does not name
+// one keeps the image it has rolled out, so that a new default never upgrades
+// an existing site.
+func wordpressImage(m *examplev1.Wordpress) string {
+	if m.Spec.Wordpress.Image != "" {
+		return m.Spec.Wordpress.Image
+	}
+	if m.Status.WordpressImage != "" {
+		return m.Status.WordpressImage
+	}
+	return defaultWordpressImage
+}
+
+// mysqlImage returns the database image of m. Like wordpressImage, it keeps
+// the image that is rolled out when m does not name one: a database server
+// only upgrades a data directory of the release series right before its own.
+func mysqlImage(m *examplev1.Wordpress) string {
+	if m.Spec.Database.Image != "" {
+		return m.Spec.Database.Image
+	}
+	if m.Status.DatabaseImage != "" {
+		return m.Status.DatabaseImage
+	}
+	return engineForWordpress(m).defaultImage
+}
+
+// recordRunningImages fills in the images of m that status does not know yet
+// from the workloads that already run them, and saves them to status. Sites
+// created by operator versions that wrote no status would otherwise be moved
+// to the default images, and the database to a release that cannot read its
+// data directory. The legacy database Deployment is read as well as the
+// StatefulSet, since it is only replaced once the image has been recorded.
+func (r *ReconcileWordpress) recordRunningImages(m *examplev1.Wordpress, reqLogger logr.Logger) error {
+	status := m.Status.DeepCopy()
+	if status.WordpressImage == "" {
+		dep := &appsv1.Deployment{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("%s-wordpress", m.Name), Namespace: m.Namespace}, dep)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		} else if err == nil {
+			status.WordpressImage = containerImage(dep.Spec.Template, "wordpress")
+		}
+	}
+	if status.DatabaseImage == "" && m.Spec.Database.External == nil {
+		mysqlName := types.NamespacedName{Name: fmt.Sprintf("%s-mysql", m.Name), Namespace: m.Namespace}
+		sts := &appsv1.StatefulSet{}
+		err := r.client.Get(context.TODO(), mysqlName, sts)
+		if err == nil {
+			status.DatabaseImage = containerImage(sts.Spec.Template, "mysql")
+		} else if errors.IsNotFound(err) {
+			dep := &appsv1.Deployment{}
+			err = r.client.Get(context.TODO(), mysqlName, dep)
+			if err == nil {
+				status.DatabaseImage = containerImage(dep.Spec.Template, "mysql")
+			}
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if status.WordpressImage == m.Status.WordpressImage && status.DatabaseImage == m.Status.DatabaseImage {
+		return nil
+	}
+	reqLogger.Info("Recording the running images", "WordpressImage", status.WordpressImage, "DatabaseImage", status.DatabaseImage)
+	m.Status = *status
+	return r.client.Status().Update(context.TODO(), m)
+}
+
+// wordpressServiceType returns the type of the WordPress Service of m.
+func wordpressServiceType(m *examplev1.Wordpress) corev1.ServiceType {
+	if m.Spec.Wordpress.ServiceType == "" {
+		return corev1.ServiceTypeLoadBalancer
+	}
+	return m.Spec.Wordpress.ServiceType
+}
+
 func labelsForWordpress(name string) map[string]string {
 	return map[string]string{"app": "wordpress", "wordpress_cr": name}
 }
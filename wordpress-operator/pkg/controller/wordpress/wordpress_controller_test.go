@@ -0,0 +1,889 @@
+package wordpress
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+	"github.com/renan-campos/wordpress-operator/pkg/redact"
+)
+
+// newTestReconciler returns a ReconcileWordpress backed by a fake client
+// holding objs.
+func newTestReconciler(t *testing.T, objs ...runtime.Object) *ReconcileWordpress {
+	s := scheme.Scheme
+	if err := examplev1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return &ReconcileWordpress{client: applyClient{fake.NewFakeClientWithScheme(s, objs...)}, scheme: s, caNamespace: "operators", ingressNamespace: "ingress-nginx"}
+}
+
+// applyClient serves server-side apply patches, which the fake client does
+// not support, the way the API server does for an object that has a single
+// owner: the applied object replaces everything but the status and the
+// metadata the server maintains.
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	existing := obj.DeepCopyObject().(client.Object)
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if errors.IsNotFound(err) {
+		return c.Create(ctx, obj)
+	} else if err != nil {
+		return err
+	}
+
+	want, err := unstructuredContent(obj)
+	if err != nil {
+		return err
+	}
+	have, err := unstructuredContent(existing)
+	if err != nil {
+		return err
+	}
+	metadata, _ := have["metadata"].(map[string]interface{})
+	applied, _ := want["metadata"].(map[string]interface{})
+	for _, field := range []string{"labels", "annotations", "ownerReferences"} {
+		if v, ok := applied[field]; ok {
+			metadata[field] = v
+		} else {
+			delete(metadata, field)
+		}
+	}
+	want["metadata"] = metadata
+	if status, ok := have["status"]; ok {
+		want["status"] = status
+	} else {
+		delete(want, "status")
+	}
+	for _, m := range []map[string]interface{}{want, have} {
+		delete(m, "apiVersion")
+		delete(m, "kind")
+	}
+	if reflect.DeepEqual(want, have) {
+		return setUnstructuredContent(obj, have)
+	}
+	if err := setUnstructuredContent(obj, want); err != nil {
+		return err
+	}
+	return c.Update(ctx, obj)
+}
+
+func unstructuredContent(obj client.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(runtime.Unstructured); ok {
+		return runtime.DeepCopyJSON(u.UnstructuredContent()), nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+func setUnstructuredContent(obj client.Object, content map[string]interface{}) error {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	defer obj.GetObjectKind().SetGroupVersionKind(gvk)
+	if u, ok := obj.(runtime.Unstructured); ok {
+		u.SetUnstructuredContent(content)
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, obj)
+}
+
+func TestReconcileCreatesSiteInOnePass(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+
+	res, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}})
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	// MySQL cannot be available yet, so the site is pending.
+	if res.RequeueAfter == 0 {
+		t.Errorf("expected a delayed requeue while mysql starts, got %+v", res)
+	}
+
+	for _, child := range []struct {
+		name string
+		obj  client.Object
+	}{
+		{"mysite", &corev1.Secret{}},
+		{"data-mysite-mysql-0", &corev1.PersistentVolumeClaim{}},
+		{"mysite-wordpress", &corev1.PersistentVolumeClaim{}},
+		{"mysite-mysql", &appsv1.StatefulSet{}},
+		{"mysite-wordpress", &appsv1.Deployment{}},
+		{"mysite-mysql-headless", &corev1.Service{}},
+		{"mysite-mysql", &corev1.Service{}},
+		{"mysite-mysql-read", &corev1.Service{}},
+		{"mysite-wordpress", &corev1.Service{}},
+		{"mysite-mysql", &networkingv1.NetworkPolicy{}},
+		{"mysite-wordpress", &networkingv1.NetworkPolicy{}},
+	} {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: child.name, Namespace: "default"}, child.obj)
+		if err != nil {
+			t.Errorf("%T %s was not created: %v", child.obj, child.name, err)
+		}
+	}
+
+	// A new site never connects as root.
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if !usesDatabaseUser(dep) {
+		t.Error("wordpress does not connect as the application user")
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Phase != examplev1.PhaseProvisioning {
+		t.Errorf("phase = %q, want %q", got.Status.Phase, examplev1.PhaseProvisioning)
+	}
+	if len(got.Status.Resources) != 11 {
+		t.Errorf("status references %d resources, want 11", len(got.Status.Resources))
+	}
+	if !hasFinalizer(got, deletionFinalizer) {
+		t.Errorf("finalizer %q was not added, got %v", deletionFinalizer, got.Finalizers)
+	}
+}
+
+func TestReconcileRollsOutNewWordpressImage(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}
+	// rollOut stands in for the StatefulSet and Deployment controllers.
+	rollOut := func() {
+		sts := &appsv1.StatefulSet{}
+		if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, sts); err != nil {
+			t.Fatal(err)
+		}
+		sts.Status = appsv1.StatefulSetStatus{Replicas: 1, ReadyReplicas: 1, CurrentRevision: "1", UpdateRevision: "1"}
+		if err := r.client.Status().Update(context.TODO(), sts); err != nil {
+			t.Fatal(err)
+		}
+		dep := &appsv1.Deployment{}
+		if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+			t.Fatal(err)
+		}
+		dep.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1, AvailableReplicas: 1}
+		if err := r.client.Status().Update(context.TODO(), dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := func() examplev1.WordpressStatus {
+		got := &examplev1.Wordpress{}
+		if err := r.client.Get(context.TODO(), req.NamespacedName, got); err != nil {
+			t.Fatal(err)
+		}
+		return got.Status
+	}
+
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if c := meta.FindStatusCondition(status().Conditions, examplev1.ConditionProgressing); c == nil || c.Reason != "RollingOut" {
+		t.Errorf("Progressing = %+v, want RollingOut", c)
+	}
+	rollOut()
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := status(); got.WordpressImage != defaultWordpressImage || got.DatabaseImage != "mysql:8.4" {
+		t.Errorf("versions = %s, %s; want the defaults %s, mysql:8.4", got.WordpressImage, got.DatabaseImage, defaultWordpressImage)
+	}
+	if c := meta.FindStatusCondition(status().Conditions, examplev1.ConditionProgressing); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("Progressing = %+v, want false once rolled out", c)
+	}
+
+	latest := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), req.NamespacedName, latest); err != nil {
+		t.Fatal(err)
+	}
+	latest.Spec.Wordpress.Image = "wordpress:6.9-apache"
+	if err := r.client.Update(context.TODO(), latest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if got := containerImage(dep.Spec.Template, "wordpress"); got != "wordpress:6.9-apache" {
+		t.Errorf("deployment image = %s, want wordpress:6.9-apache", got)
+	}
+
+	rollOut()
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := status(); got.WordpressImage != "wordpress:6.9-apache" || got.DatabaseImage != "mysql:8.4" {
+		t.Errorf("versions = %s, %s; want wordpress:6.9-apache, mysql:8.4", got.WordpressImage, got.DatabaseImage)
+	}
+}
+
+func TestImagesDefaultToWhatIsRolledOut(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		Status: examplev1.WordpressStatus{WordpressImage: "wordpress:4.8-apache", DatabaseImage: "mysql:5.6"},
+	}
+	if got := wordpressImage(wp); got != "wordpress:4.8-apache" {
+		t.Errorf("wordpress image = %s, want the one rolled out", got)
+	}
+	if got := mysqlImage(wp); got != "mysql:5.6" {
+		t.Errorf("database image = %s, want the one rolled out", got)
+	}
+
+	wp.Status = examplev1.WordpressStatus{}
+	if got := wordpressImage(wp); got != defaultWordpressImage {
+		t.Errorf("wordpress image of a new site = %s, want %s", got, defaultWordpressImage)
+	}
+	if got := mysqlImage(wp); got != "mysql:8.4" {
+		t.Errorf("database image of a new site = %s, want mysql:8.4", got)
+	}
+}
+
+func TestUpdateStatusFailsOnlyOnTerminalErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		err        error
+		conditions []metav1.Condition
+		phase      examplev1.WordpressPhase
+		reason     string
+		progress   string
+	}{
+		{
+			name:     "transient error",
+			err:      utilerrors.NewAggregate([]error{fmt.Errorf("database: %w", goerrors.New("connection refused"))}),
+			phase:    examplev1.PhaseProvisioning,
+			reason:   "ComponentsNotReady",
+			progress: "ReconcileRetrying",
+		},
+		{
+			name:     "failed Job",
+			err:      utilerrors.NewAggregate([]error{fmt.Errorf("replicas: %w", &jobFailedError{"job mysite-mysql-seed-1 failed to seed read replica mysite-mysql-1"})}),
+			phase:    examplev1.PhaseFailed,
+			reason:   "JobFailed",
+			progress: "JobFailed",
+		},
+		{
+			name:       "rejected resize",
+			conditions: []metav1.Condition{{Type: examplev1.ConditionStorageResizeFailed, Status: metav1.ConditionTrue, Reason: "ResizeRejected"}},
+			phase:      examplev1.PhaseFailed,
+			reason:     "ResizeRejected",
+			progress:   "ResizeRejected",
+		},
+		{
+			name:     "conflict",
+			err:      errors.NewConflict(schema.GroupResource{Resource: "wordpresses"}, "mysite", goerrors.New("modified")),
+			phase:    examplev1.PhaseProvisioning,
+			reason:   "ComponentsNotReady",
+			progress: "UpToDate",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wp := &examplev1.Wordpress{
+				ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+				Status:     examplev1.WordpressStatus{Conditions: tc.conditions},
+			}
+			r := newTestReconciler(t, wp)
+			if err := r.updateStatus(wp, wp.Status.DeepCopy(), tc.err); err != nil {
+				t.Fatal(err)
+			}
+
+			if wp.Status.Phase != tc.phase {
+				t.Errorf("phase = %q, want %q", wp.Status.Phase, tc.phase)
+			}
+			if c := meta.FindStatusCondition(wp.Status.Conditions, examplev1.ConditionReady); c == nil || c.Reason != tc.reason {
+				t.Errorf("Ready = %+v, want reason %s", c, tc.reason)
+			}
+			if c := meta.FindStatusCondition(wp.Status.Conditions, examplev1.ConditionProgressing); c == nil || c.Reason != tc.progress {
+				t.Errorf("Progressing = %+v, want reason %s", c, tc.progress)
+			}
+		})
+	}
+}
+
+func TestReconcileReportsLoadBalancerAddress(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), req.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.URL != "" || len(got.Status.Endpoints) != 0 {
+		t.Errorf("URL = %q, endpoints = %v before the load balancer has an address", got.Status.URL, got.Status.Endpoints)
+	}
+
+	svc := &corev1.Service{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}, {IP: "203.0.113.7"}}
+	if err := r.client.Status().Update(context.TODO(), svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	got = &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), req.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.URL != "http://lb.example.com" {
+		t.Errorf("URL = %q, want the first load balancer address", got.Status.URL)
+	}
+	if !reflect.DeepEqual(got.Status.Endpoints, []string{"lb.example.com", "203.0.113.7"}) {
+		t.Errorf("endpoints = %v, want every load balancer address", got.Status.Endpoints)
+	}
+}
+
+func TestDeletionPolicyRetainOrphansData(t *testing.T) {
+	now := metav1.Now()
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "mysite",
+			Namespace:         "default",
+			UID:               "1234",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{deletionFinalizer},
+		},
+		Spec: examplev1.WordpressSpec{DeletionPolicy: examplev1.DeletionPolicyRetain},
+	}
+	r := newTestReconciler(t, wp)
+	for _, obj := range []metav1.Object{
+		r.secretForWordpress(wp, map[string][]byte{secretRootPasswordKey: []byte("secret")}),
+		r.mysqlPVCForWordpress(wp, legacyDatabaseClaimName(wp)),
+		r.wordpressPVCForWordpress(wp),
+	} {
+		if len(obj.GetOwnerReferences()) == 0 {
+			t.Fatalf("%s is not owned by the Wordpress", obj.GetName())
+		}
+		if err := r.client.Create(context.TODO(), obj.(client.Object)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}})
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	for _, child := range []struct {
+		name string
+		obj  client.Object
+	}{
+		{"mysite", &corev1.Secret{}},
+		{"mysite-mysql", &corev1.PersistentVolumeClaim{}},
+		{"mysite-wordpress", &corev1.PersistentVolumeClaim{}},
+	} {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: child.name, Namespace: "default"}, child.obj)
+		if err != nil {
+			t.Fatalf("%T %s: %v", child.obj, child.name, err)
+		}
+		o := child.obj.(metav1.Object)
+		if len(o.GetOwnerReferences()) != 0 {
+			t.Errorf("%T %s is still owned: %v", child.obj, child.name, o.GetOwnerReferences())
+		}
+		if o.GetLabels()[retainedLabel] != "mysite" {
+			t.Errorf("%T %s is not labeled as retained: %v", child.obj, child.name, o.GetLabels())
+		}
+	}
+
+	// The site is gone once its finalizer is removed.
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, &examplev1.Wordpress{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("the site was not deleted once finalizer %q was removed: %v", deletionFinalizer, err)
+	}
+}
+
+func TestDeletionPolicyDeleteLeavesDataOwned(t *testing.T) {
+	now := metav1.Now()
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "mysite",
+			Namespace:         "default",
+			UID:               "1234",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{deletionFinalizer},
+		},
+		Spec: examplev1.WordpressSpec{DeletionPolicy: examplev1.DeletionPolicyDelete},
+	}
+	r := newTestReconciler(t, wp)
+	for _, obj := range []client.Object{
+		r.secretForWordpress(wp, map[string][]byte{secretRootPasswordKey: []byte("secret")}),
+		r.mysqlPVCForWordpress(wp, databasePodClaimName(wp, 0)),
+		r.wordpressPVCForWordpress(wp),
+	} {
+		if err := r.client.Create(context.TODO(), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}})
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	// The garbage collector deletes the data once the finalizer is gone, so
+	// it must still be owned by the Wordpress.
+	for _, child := range []struct {
+		name string
+		obj  client.Object
+	}{
+		{"mysite", &corev1.Secret{}},
+		{"data-mysite-mysql-0", &corev1.PersistentVolumeClaim{}},
+		{"mysite-wordpress", &corev1.PersistentVolumeClaim{}},
+	} {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: child.name, Namespace: "default"}, child.obj)
+		if err != nil {
+			t.Fatalf("%T %s: %v", child.obj, child.name, err)
+		}
+		if refs := child.obj.GetOwnerReferences(); len(refs) != 1 || refs[0].UID != wp.UID {
+			t.Errorf("%T %s is not owned by the Wordpress: %v", child.obj, child.name, refs)
+		}
+		if _, retained := child.obj.GetLabels()[retainedLabel]; retained {
+			t.Errorf("%T %s is labeled as retained", child.obj, child.name)
+		}
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: backupJobName(wp), Namespace: "default"}, &batchv1.Job{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("a backup was started for a site without a backup: %v", err)
+	}
+
+	// The site is gone once its finalizer is removed.
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, &examplev1.Wordpress{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("the site was not deleted once finalizer %q was removed: %v", deletionFinalizer, err)
+	}
+}
+
+func TestDeletionPolicyBackupThenDeleteHoldsDataUntilBackedUp(t *testing.T) {
+	now := metav1.Now()
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "mysite",
+			Namespace:         "default",
+			UID:               "1234",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{deletionFinalizer},
+		},
+		Spec: examplev1.WordpressSpec{DeletionPolicy: examplev1.DeletionPolicyBackupThenDelete},
+	}
+	r := newTestReconciler(t, wp)
+	for _, obj := range []client.Object{
+		r.secretForWordpress(wp, map[string][]byte{secretRootPasswordKey: []byte("secret")}),
+		r.mysqlPVCForWordpress(wp, databasePodClaimName(wp, 0)),
+		r.wordpressPVCForWordpress(wp),
+		r.mysqlStatefulSetForWordpress(wp, ""),
+		r.mysqlServiceForWordpress(wp),
+		r.wordpressDeploymentForWordpress(wp),
+	} {
+		if err := r.client.Create(context.TODO(), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}
+	held := []struct {
+		name string
+		obj  client.Object
+	}{
+		{"mysite", &corev1.Secret{}},
+		{"data-mysite-mysql-0", &corev1.PersistentVolumeClaim{}},
+		{"mysite-wordpress", &corev1.PersistentVolumeClaim{}},
+		{"mysite-mysql", &appsv1.StatefulSet{}},
+		{"mysite-mysql", &corev1.Service{}},
+	}
+
+	res, err := r.Reconcile(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if res.RequeueAfter == 0 {
+		t.Errorf("expected a delayed requeue while the backup runs, got %+v", res)
+	}
+
+	// Nothing the backup needs is left for the garbage collector to delete
+	// while it runs, the backup Job included.
+	for _, child := range held {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: child.name, Namespace: "default"}, child.obj)
+		if err != nil {
+			t.Fatalf("%T %s: %v", child.obj, child.name, err)
+		}
+		if refs := child.obj.GetOwnerReferences(); len(refs) != 0 {
+			t.Errorf("%T %s is still owned during the backup: %v", child.obj, child.name, refs)
+		}
+		if child.obj.GetLabels()[backupHoldLabel] != "mysite" {
+			t.Errorf("%T %s is not labeled as held: %v", child.obj, child.name, child.obj.GetLabels())
+		}
+	}
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: backupJobName(wp), Namespace: "default"}, job); err != nil {
+		t.Fatalf("backup Job was not created: %v", err)
+	}
+	if len(job.OwnerReferences) != 0 {
+		t.Errorf("backup Job is owned: %v", job.OwnerReferences)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, &appsv1.Deployment{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("wordpress was not stopped for the backup: %v", err)
+	}
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), req.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	if !hasFinalizer(got, deletionFinalizer) {
+		t.Fatal("finalizer was removed before the backup completed")
+	}
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := r.client.Status().Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	for _, child := range held {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: child.name, Namespace: "default"}, child.obj)
+		if !errors.IsNotFound(err) {
+			t.Errorf("%T %s was not deleted after the backup: %v", child.obj, child.name, err)
+		}
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: backupJobName(wp), Namespace: "default"}, &batchv1.Job{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("backup Job was not deleted after the backup: %v", err)
+	}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-backup", Namespace: "default"}, &corev1.PersistentVolumeClaim{}); err != nil {
+		t.Errorf("backup claim was not kept: %v", err)
+	}
+	// The site is gone once its finalizer is removed.
+	err = r.client.Get(context.TODO(), req.NamespacedName, &examplev1.Wordpress{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("the site was not deleted once finalizer %q was removed: %v", deletionFinalizer, err)
+	}
+}
+
+func TestReconcileExternalDatabase(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Database: examplev1.DatabaseSpec{
+				External: &examplev1.ExternalDatabaseSpec{
+					Host: "db.example.com",
+					User: "site",
+					CredentialsSecretRef: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "db-credentials"},
+						Key:                  "password",
+					},
+				},
+			},
+		},
+	}
+	creds := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	r := newTestReconciler(t, wp, creds)
+
+	res, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}})
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	// The connection has not been verified yet.
+	if res.RequeueAfter == 0 {
+		t.Errorf("expected a delayed requeue while the database is checked, got %+v", res)
+	}
+
+	for _, child := range []client.Object{&corev1.PersistentVolumeClaim{}, &appsv1.StatefulSet{}, &corev1.Service{}} {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, child)
+		if err == nil {
+			t.Errorf("%T mysite-mysql was created for an external database", child)
+		}
+	}
+
+	checks := &batchv1.JobList{}
+	if err := r.client.List(context.TODO(), checks, client.MatchingLabels(databaseCheckLabels("mysite"))); err != nil {
+		t.Fatal(err)
+	}
+	if len(checks.Items) != 1 {
+		t.Fatalf("found %d database checks, want 1", len(checks.Items))
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range dep.Spec.Template.Spec.Containers[0].Env {
+		if e.Name == "WORDPRESS_DB_HOST" && e.Value != "db.example.com:3306" {
+			t.Errorf("WORDPRESS_DB_HOST = %q, want db.example.com:3306", e.Value)
+		}
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if meta.IsStatusConditionTrue(got.Status.Conditions, examplev1.ConditionDatabaseReady) {
+		t.Error("database is ready before the connection was verified")
+	}
+}
+
+func TestDatabaseCheckJobNameFollowsSecretVersion(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Database: examplev1.DatabaseSpec{
+				External: &examplev1.ExternalDatabaseSpec{Host: "db.example.com", User: "site"},
+			},
+		},
+	}
+	creds := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "default", UID: "5678", ResourceVersion: "1"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	r := newTestReconciler(t)
+
+	name := r.databaseCheckJobForWordpress(wp, creds).Name
+	// The name is no oracle for the password: it does not depend on it.
+	creds.Data["password"] = []byte("guess")
+	if got := r.databaseCheckJobForWordpress(wp, creds).Name; got != name {
+		t.Errorf("name = %s with another password in the same Secret version, want %s", got, name)
+	}
+	creds.ResourceVersion = "2"
+	if got := r.databaseCheckJobForWordpress(wp, creds).Name; got == name {
+		t.Errorf("name = %s after the Secret changed, want a new check", got)
+	}
+}
+
+func TestMysqlStatefulSetForMariaDB(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec: examplev1.WordpressSpec{
+			Database: examplev1.DatabaseSpec{Engine: examplev1.DatabaseEngineMariaDB},
+		},
+	}
+	r := newTestReconciler(t)
+
+	sts := r.mysqlStatefulSetForWordpress(wp, "")
+	c := sts.Spec.Template.Spec.Containers[0]
+	if c.Image != "mariadb:10.11" {
+		t.Errorf("image = %q, want the default MariaDB image", c.Image)
+	}
+	for _, e := range c.Env {
+		if !strings.HasPrefix(e.Name, "MARIADB_") {
+			t.Errorf("environment variable %s is not read by MariaDB", e.Name)
+		}
+	}
+	if c.ReadinessProbe == nil || !strings.Contains(c.ReadinessProbe.Exec.Command[2], `"$MARIADB_ROOT_PASSWORD" exec mariadb-admin ping`) {
+		t.Errorf("readiness probe = %+v, want mariadb-admin ping", c.ReadinessProbe)
+	}
+	if podEngine(sts.Spec.Template) != examplev1.DatabaseEngineMariaDB {
+		t.Error("the StatefulSet is not recognized as running MariaDB")
+	}
+}
+
+func TestEnsureDatabaseAdoptsLegacyClaim(t *testing.T) {
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	r := newTestReconciler(t, wp)
+	// A site created when MySQL ran as a Deployment.
+	legacy := []client.Object{
+		r.mysqlPVCForWordpress(wp, "mysite-mysql"),
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "mysite-mysql", Namespace: "default"}},
+	}
+	for _, obj := range legacy {
+		if err := r.client.Create(context.TODO(), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reqLogger := log.WithValues("Request.Name", "mysite")
+
+	// The Deployment has to be gone before the StatefulSet starts.
+	sts, err := r.ensureDatabase(wp, reqLogger)
+	if err != nil || sts != nil {
+		t.Fatalf("ensureDatabase = %v, %v; want it to wait for the Deployment", sts, err)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, &appsv1.Deployment{})
+	if err == nil {
+		t.Fatal("the mysql Deployment was not deleted")
+	}
+
+	if _, err := r.ensureDatabase(wp, reqLogger); err != nil {
+		t.Fatal(err)
+	}
+	got := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Spec.VolumeClaimTemplates) != 0 {
+		t.Error("the StatefulSet claims a new volume instead of adopting the existing one")
+	}
+	vols := got.Spec.Template.Spec.Volumes
+	if len(vols) != 1 || vols[0].PersistentVolumeClaim == nil || vols[0].PersistentVolumeClaim.ClaimName != "mysite-mysql" {
+		t.Errorf("volumes = %+v, want the claim mysite-mysql", vols)
+	}
+}
+
+func TestReconcileKeepsImagesOfSiteWithoutStatus(t *testing.T) {
+	// A site created by an operator that wrote no status, with MySQL still a
+	// Deployment.
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+	}
+	podTemplate := func(name, image string) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: image}}}}
+	}
+	mysql := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite-mysql", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: podTemplate("mysql", "mysql:5.6")},
+	}
+	wordpress := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite-wordpress", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: podTemplate("wordpress", "wordpress:4.8-apache")},
+	}
+	r := newTestReconciler(t, wp, mysql, wordpress)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}
+
+	// The first pass deletes the mysql Deployment, the second starts the StatefulSet.
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.TODO(), req); err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+	}
+
+	got := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), req.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.DatabaseImage != "mysql:5.6" || got.Status.WordpressImage != "wordpress:4.8-apache" {
+		t.Errorf("status images = %q, %q; want the running ones", got.Status.DatabaseImage, got.Status.WordpressImage)
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-mysql", Namespace: "default"}, sts); err != nil {
+		t.Fatalf("the mysql StatefulSet was not created: %v", err)
+	}
+	if image := containerImage(sts.Spec.Template, "mysql"); image != "mysql:5.6" {
+		t.Errorf("mysql image = %q, want mysql:5.6", image)
+	}
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "mysite-wordpress", Namespace: "default"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if image := containerImage(dep.Spec.Template, "wordpress"); image != "wordpress:4.8-apache" {
+		t.Errorf("wordpress image = %q, want wordpress:4.8-apache", image)
+	}
+}
+
+// captureLogger prints every message, error and key/value pair it receives
+// into buf, at every verbosity.
+type captureLogger struct {
+	buf    *strings.Builder
+	values []interface{}
+}
+
+func (c captureLogger) Init(logr.RuntimeInfo) {}
+
+func (c captureLogger) Enabled(level int) bool { return true }
+
+func (c captureLogger) Info(level int, msg string, keysAndValues ...interface{}) {
+	fmt.Fprintf(c.buf, "%s %+v %+v\n", msg, c.values, keysAndValues)
+}
+
+func (c captureLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	fmt.Fprintf(c.buf, "%v %s %+v %+v\n", err, msg, c.values, keysAndValues)
+}
+
+func (c captureLogger) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return captureLogger{c.buf, append(append([]interface{}{}, c.values...), keysAndValues...)}
+}
+
+func (c captureLogger) WithName(name string) logr.LogSink { return c }
+
+// secretRejectingClient fails to apply Secrets the way an admission webhook
+// that echoes the object does.
+type secretRejectingClient struct {
+	client.Client
+}
+
+func (c secretRejectingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if sec, ok := obj.(*corev1.Secret); ok {
+		return fmt.Errorf(`admission webhook "secrets.example.com" denied the request: {"data":{"password":%q}}`, sec.Data[secretRootPasswordKey])
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestReconcileKeepsPasswordsOutOfLogs(t *testing.T) {
+	const password = "DirtyLittleSecret"
+
+	buf := &strings.Builder{}
+	defer func(l logr.Logger) { log = l }(log)
+	log = redact.NewLogger(logr.New(captureLogger{buf: buf}))
+
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Spec:       examplev1.WordpressSpec{Password: password},
+	}
+	r := newTestReconciler(t, wp)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "mysite", Namespace: "default"}}
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	r.client = secretRejectingClient{r.client}
+	latest := &examplev1.Wordpress{}
+	if err := r.client.Get(context.TODO(), req.NamespacedName, latest); err != nil {
+		t.Fatal(err)
+	}
+	latest.Spec.Password = password + "2"
+	if err := r.client.Update(context.TODO(), latest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.TODO(), req); err == nil {
+		t.Fatal("expected the rejected Secret to fail the reconcile")
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "Reconciling Wordpress") || !strings.Contains(out, "denied the request") {
+		t.Fatalf("expected the reconcile and its failure in log output:\n%s", out)
+	}
+	if strings.Contains(out, password) {
+		t.Errorf("password found in log output:\n%s", out)
+	}
+}
@@ -0,0 +1,64 @@
+// Package k8sutil finds the namespaces the operator runs in and watches.
+package k8sutil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// WatchNamespaceEnvVar is the constant for env variable WATCH_NAMESPACE
+// which specifies the Namespace to watch.
+// An empty value means the operator is running with cluster scope.
+const WatchNamespaceEnvVar = "WATCH_NAMESPACE"
+
+// namespaceFile holds the namespace of the pod the operator runs in.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// ErrRunLocal is returned when the operator runs outside a cluster.
+var ErrRunLocal = errors.New("operator run mode forced to local")
+
+// GetWatchNamespace returns the Namespace the operator should be watching
+// for changes.
+func GetWatchNamespace() (string, error) {
+	ns, found := os.LookupEnv(WatchNamespaceEnvVar)
+	if !found {
+		return "", fmt.Errorf("%s must be set", WatchNamespaceEnvVar)
+	}
+	return ns, nil
+}
+
+// GetOperatorNamespace returns the namespace the operator runs in, or
+// ErrRunLocal when it runs outside a cluster.
+func GetOperatorNamespace() (string, error) {
+	ns, err := ioutil.ReadFile(namespaceFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrRunLocal
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(ns)), nil
+}
+
+// OperatorNameEnvVar is the constant for env variable OPERATOR_NAME
+// which is the name of the current operator.
+const OperatorNameEnvVar = "OPERATOR_NAME"
+
+// PodNameEnvVar is the constant for env variable POD_NAME
+// which is the name of the pod the operator runs in.
+const PodNameEnvVar = "POD_NAME"
+
+// GetOperatorName returns the name of the operator.
+func GetOperatorName() (string, error) {
+	name, found := os.LookupEnv(OperatorNameEnvVar)
+	if !found {
+		return "", fmt.Errorf("%s must be set", OperatorNameEnvVar)
+	}
+	if len(name) == 0 {
+		return "", fmt.Errorf("%s must not be empty", OperatorNameEnvVar)
+	}
+	return name, nil
+}
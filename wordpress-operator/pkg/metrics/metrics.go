@@ -0,0 +1,188 @@
+// Package metrics exposes the metrics of the operator to Prometheus: a
+// Service in front of the metrics port, and a prometheus-operator
+// ServiceMonitor that scrapes it.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/renan-campos/wordpress-operator/pkg/k8sutil"
+)
+
+// OperatorPortName is the name of the port serving the operator's metrics.
+const OperatorPortName = "http-metrics"
+
+// ErrServiceMonitorNotPresent is returned when the prometheus-operator
+// ServiceMonitor kind is not served by the cluster.
+var ErrServiceMonitorNotPresent = errors.New("no ServiceMonitor registered with the API")
+
+var serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+
+// CreateMetricsService creates a Service exposing servicePorts of the
+// operator pods, in the namespace the operator runs in, or updates its ports.
+// The Service is owned by the operator Deployment.
+func CreateMetricsService(ctx context.Context, cfg *rest.Config, servicePorts []corev1.ServicePort) (*corev1.Service, error) {
+	operatorName, err := k8sutil.GetOperatorName()
+	if err != nil {
+		return nil, err
+	}
+	ns, err := k8sutil.GetOperatorNamespace()
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+	owner, err := operatorDeployment(ctx, c, ns)
+	if err != nil {
+		return nil, err
+	}
+	return createOrUpdateService(ctx, c, metricsService(operatorName, ns, owner, servicePorts))
+}
+
+// metricsService returns the metrics Service of the operator operatorName.
+func metricsService(operatorName, ns string, owner *metav1.OwnerReference, servicePorts []corev1.ServicePort) *corev1.Service {
+	ls := map[string]string{"name": operatorName}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-metrics", operatorName),
+			Namespace: ns,
+			Labels:    ls,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports:    servicePorts,
+			Selector: ls,
+		},
+	}
+	if owner != nil {
+		svc.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	return svc
+}
+
+func createOrUpdateService(ctx context.Context, c client.Client, svc *corev1.Service) (*corev1.Service, error) {
+	err := c.Create(ctx, svc)
+	if !apierrors.IsAlreadyExists(err) {
+		return svc, err
+	}
+	found := &corev1.Service{}
+	if err := c.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, found); err != nil {
+		return nil, err
+	}
+	found.Labels = svc.Labels
+	found.OwnerReferences = svc.OwnerReferences
+	found.Spec.Ports = svc.Spec.Ports
+	found.Spec.Selector = svc.Spec.Selector
+	return found, c.Update(ctx, found)
+}
+
+// operatorDeployment returns a reference to the Deployment that runs the
+// operator pod, found through its ReplicaSet. It returns nil when the pod is
+// not known or not run by a Deployment.
+func operatorDeployment(ctx context.Context, c client.Client, ns string) (*metav1.OwnerReference, error) {
+	podName := os.Getenv(k8sutil.PodNameEnvVar)
+	if podName == "" {
+		return nil, nil
+	}
+	pod := &corev1.Pod{}
+	if err := c.Get(ctx, types.NamespacedName{Name: podName, Namespace: ns}, pod); err != nil {
+		return nil, err
+	}
+	rsRef := metav1.GetControllerOf(pod)
+	if rsRef == nil || rsRef.Kind != "ReplicaSet" {
+		return nil, nil
+	}
+	rs := &appsv1.ReplicaSet{}
+	if err := c.Get(ctx, types.NamespacedName{Name: rsRef.Name, Namespace: ns}, rs); err != nil {
+		return nil, err
+	}
+	depRef := metav1.GetControllerOf(rs)
+	if depRef == nil || depRef.Kind != "Deployment" {
+		return nil, nil
+	}
+	return &metav1.OwnerReference{APIVersion: depRef.APIVersion, Kind: depRef.Kind, Name: depRef.Name, UID: depRef.UID}, nil
+}
+
+// CreateServiceMonitors creates a ServiceMonitor in ns for each of services,
+// so that Prometheus scrapes every named port of the Service. It returns
+// ErrServiceMonitorNotPresent when the prometheus-operator is not installed.
+func CreateServiceMonitors(ctx context.Context, cfg *rest.Config, ns string, services []*corev1.Service) ([]*unstructured.Unstructured, error) {
+	c, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return createServiceMonitors(ctx, c, ns, services)
+}
+
+func createServiceMonitors(ctx context.Context, c client.Client, ns string, services []*corev1.Service) ([]*unstructured.Unstructured, error) {
+	_, err := c.RESTMapper().RESTMapping(serviceMonitorGVK.GroupKind(), serviceMonitorGVK.Version)
+	if meta.IsNoMatchError(err) {
+		return nil, ErrServiceMonitorNotPresent
+	} else if err != nil {
+		return nil, err
+	}
+
+	var monitors []*unstructured.Unstructured
+	for _, svc := range services {
+		if svc == nil {
+			continue
+		}
+		sm := serviceMonitorForService(ns, svc)
+		if err := c.Create(ctx, sm); err != nil && !apierrors.IsAlreadyExists(err) {
+			return monitors, err
+		}
+		monitors = append(monitors, sm)
+	}
+	return monitors, nil
+}
+
+// serviceMonitorForService returns a ServiceMonitor in ns scraping the named
+// ports of svc. It is owned by svc, and goes away with it.
+func serviceMonitorForService(ns string, svc *corev1.Service) *unstructured.Unstructured {
+	var endpoints []interface{}
+	for _, port := range svc.Spec.Ports {
+		if port.Name == "" {
+			continue
+		}
+		endpoints = append(endpoints, map[string]interface{}{"port": port.Name})
+	}
+	matchLabels := map[string]interface{}{}
+	for k, v := range svc.Labels {
+		matchLabels[k] = v
+	}
+
+	sm := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"selector":  map[string]interface{}{"matchLabels": matchLabels},
+			"endpoints": endpoints,
+		},
+	}}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	sm.SetName(svc.Name)
+	sm.SetNamespace(ns)
+	sm.SetLabels(svc.Labels)
+	if svc.UID != "" {
+		sm.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Name:       svc.Name,
+			UID:        svc.UID,
+		}})
+	}
+	return sm
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCreateMetricsServiceAndMonitor(t *testing.T) {
+	owner := &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "wordpress-operator", UID: "uid"}
+	ports := []corev1.ServicePort{{Name: OperatorPortName, Port: 8383, TargetPort: intstr.FromInt(8383)}}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	c := fake.NewClientBuilder().WithRESTMapper(mapper).Build()
+	ctx := context.TODO()
+
+	svc, err := createOrUpdateService(ctx, c, metricsService("wordpress-operator", "operators", owner, ports))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Name != "wordpress-operator-metrics" || svc.Spec.Selector["name"] != "wordpress-operator" {
+		t.Errorf("service %s selects %v, want wordpress-operator-metrics selecting the operator pods", svc.Name, svc.Spec.Selector)
+	}
+
+	// A restarted operator updates the Service it created before.
+	ports = append(ports, corev1.ServicePort{Name: "http-extra", Port: 8686})
+	if _, err := createOrUpdateService(ctx, c, metricsService("wordpress-operator", "operators", owner, ports)); err != nil {
+		t.Fatal(err)
+	}
+	got := &corev1.Service{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "wordpress-operator-metrics", Namespace: "operators"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Spec.Ports) != 2 || len(got.OwnerReferences) != 1 || got.OwnerReferences[0].Name != "wordpress-operator" {
+		t.Errorf("service ports %v, owners %v; want both ports, owned by the operator Deployment", got.Spec.Ports, got.OwnerReferences)
+	}
+
+	// Without the prometheus-operator, there is no ServiceMonitor to create.
+	if _, err := createServiceMonitors(ctx, c, "operators", []*corev1.Service{got}); err != ErrServiceMonitorNotPresent {
+		t.Fatalf("createServiceMonitors = %v, want ErrServiceMonitorNotPresent", err)
+	}
+
+	mapper.Add(serviceMonitorGVK, meta.RESTScopeNamespace)
+	if _, err := createServiceMonitors(ctx, c, "operators", []*corev1.Service{got}); err != nil {
+		t.Fatal(err)
+	}
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	if err := c.Get(ctx, types.NamespacedName{Name: "wordpress-operator-metrics", Namespace: "operators"}, sm); err != nil {
+		t.Fatalf("the ServiceMonitor was not created: %v", err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+	if len(endpoints) != 2 {
+		t.Errorf("endpoints = %v, want one per named port", endpoints)
+	}
+	name, _, _ := unstructured.NestedString(sm.Object, "spec", "selector", "matchLabels", "name")
+	if name != "wordpress-operator" {
+		t.Errorf("ServiceMonitor selects name=%q, want the metrics Service", name)
+	}
+
+	// Creating it again is not an error.
+	if _, err := createServiceMonitors(ctx, c, "operators", []*corev1.Service{got}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,289 @@
+// Package redact keeps credentials out of the operator's logs.
+//
+// Logger wraps a logr.Logger and scrubs every key/value pair and error before
+// it is handed to the wrapped logger, at every verbosity level. Values are
+// redacted when their key names a credential (e.g. "password") or the data of
+// a Secret (e.g. "Secret.Data"), or when the value is an object known to carry
+// credentials: a Secret, environment variables named after a credential, the
+// pods of a workload, or a Wordpress with a plaintext sqlRootPassword. In
+// errors, whatever follows a credential name is scrubbed.
+package redact
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// Placeholder replaces every redacted value.
+const Placeholder = "<redacted>"
+
+// lastAppliedAnnotation is written by `kubectl apply` and holds a full copy
+// of the applied object, credentials included.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// sensitiveKeyParts are matched case-insensitively against log keys.
+var sensitiveKeyParts = []string{"password", "passwd", "token", "credential"}
+
+// secretDataFields are the fields of a Secret that hold its values. A log key
+// naming one of them, alone or after a dot, carries the data of a Secret.
+var secretDataFields = []string{"data", "stringdata"}
+
+// sensitiveText matches a credential name in free text together with the
+// value that follows it, such as `password=...`, `"password":"..."` or
+// `IDENTIFIED BY '...'`. The value is the last group.
+var sensitiveText = regexp.MustCompile(`(?i)((?:password|passwd|pwd|token|credential)[\w.-]*"?\s*[:=]\s*|identified by\s+)("[^"]*"|'[^']*'|[^\s,;}]+)`)
+
+// NewLogger returns a logr.Logger that redacts sensitive values before
+// passing them on to l.
+func NewLogger(l logr.Logger) logr.Logger {
+	return logr.New(&sink{l.GetSink()})
+}
+
+type sink struct {
+	s logr.LogSink
+}
+
+func (r *sink) Init(info logr.RuntimeInfo) {
+	// Count the frame of this sink, so that callers are reported correctly.
+	info.CallDepth++
+	r.s.Init(info)
+}
+
+func (r *sink) Enabled(level int) bool {
+	return r.s.Enabled(level)
+}
+
+func (r *sink) Info(level int, msg string, keysAndValues ...interface{}) {
+	r.s.Info(level, msg, KeysAndValues(keysAndValues)...)
+}
+
+func (r *sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	r.s.Error(Error(err), msg, KeysAndValues(keysAndValues)...)
+}
+
+func (r *sink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &sink{r.s.WithValues(KeysAndValues(keysAndValues)...)}
+}
+
+func (r *sink) WithName(name string) logr.LogSink {
+	return &sink{r.s.WithName(name)}
+}
+
+func (r *sink) WithCallDepth(depth int) logr.LogSink {
+	if s, ok := r.s.(logr.CallDepthLogSink); ok {
+		return &sink{s.WithCallDepth(depth)}
+	}
+	return r
+}
+
+// KeysAndValues returns a copy of the logr key/value pairs with the sensitive
+// values redacted.
+func KeysAndValues(keysAndValues []interface{}) []interface{} {
+	out := make([]interface{}, len(keysAndValues))
+	for i := range keysAndValues {
+		if i%2 == 0 {
+			out[i] = keysAndValues[i]
+			continue
+		}
+		key, _ := keysAndValues[i-1].(string)
+		out[i] = Value(key, keysAndValues[i])
+	}
+	return out
+}
+
+// Value returns v with its sensitive content redacted. The whole value is
+// redacted when key names a credential; when key names the data of a Secret,
+// only the keys of the data are kept.
+func Value(key string, v interface{}) interface{} {
+	if SensitiveKey(key) {
+		return Placeholder
+	}
+	if secretDataKey(key) {
+		return secretData(v)
+	}
+
+	switch o := v.(type) {
+	case []byte:
+		// Raw bytes are how Secret data travels; never print them.
+		return Placeholder
+	case error:
+		return Error(o)
+	case corev1.EnvVar:
+		return env([]corev1.EnvVar{o})[0]
+	case []corev1.EnvVar:
+		return env(o)
+	case *corev1.Secret:
+		if o == nil {
+			return o
+		}
+		return secret(o)
+	case corev1.Secret:
+		return *secret(&o)
+	case *appsv1.Deployment:
+		if o == nil {
+			return o
+		}
+		out := o.DeepCopy()
+		podTemplate(&out.Spec.Template)
+		return out
+	case *appsv1.StatefulSet:
+		if o == nil {
+			return o
+		}
+		out := o.DeepCopy()
+		podTemplate(&out.Spec.Template)
+		return out
+	case *batchv1.Job:
+		if o == nil {
+			return o
+		}
+		out := o.DeepCopy()
+		podTemplate(&out.Spec.Template)
+		return out
+	case *examplev1.Wordpress:
+		if o == nil {
+			return o
+		}
+		return wordpress(o)
+	case examplev1.Wordpress:
+		return *wordpress(&o)
+	case examplev1.WordpressSpec:
+		return wordpressSpec(o)
+	}
+	return v
+}
+
+// Error returns err with the values of the credentials it names scrubbed.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	scrubbed := sensitiveText.ReplaceAllStringFunc(msg, func(match string) string {
+		name := sensitiveText.FindStringSubmatch(match)[1]
+		if strings.HasSuffix(strings.ToLower(strings.TrimRight(name, `": =`)), "ref") {
+			// A reference such as passwordSecretRef names where a
+			// credential is kept, not the credential itself.
+			return match
+		}
+		return name + Placeholder
+	})
+	if scrubbed == msg {
+		return err
+	}
+	return errors.New(scrubbed)
+}
+
+// SensitiveKey reports whether a log key names a credential.
+func SensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretDataKey reports whether a log key names the data of a Secret.
+func secretDataKey(key string) bool {
+	key = strings.ToLower(key)
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	for _, field := range secretDataFields {
+		if key == field {
+			return true
+		}
+	}
+	return false
+}
+
+// secretData returns the data of a Secret with its values redacted. The keys
+// tell what is in it and are kept.
+func secretData(v interface{}) interface{} {
+	switch o := v.(type) {
+	case map[string][]byte:
+		if o == nil {
+			return o
+		}
+		out := make(map[string][]byte, len(o))
+		for k := range o {
+			out[k] = []byte(Placeholder)
+		}
+		return out
+	case map[string]string:
+		if o == nil {
+			return o
+		}
+		out := make(map[string]string, len(o))
+		for k := range o {
+			out[k] = Placeholder
+		}
+		return out
+	}
+	return Placeholder
+}
+
+// env returns a copy of vars with the values of those named after a
+// credential redacted. Values read from a Secret are only referenced and kept.
+func env(vars []corev1.EnvVar) []corev1.EnvVar {
+	out := make([]corev1.EnvVar, len(vars))
+	for i, v := range vars {
+		if v.Value != "" && SensitiveKey(v.Name) {
+			v.Value = Placeholder
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// podTemplate redacts the environment of the containers of t in place.
+func podTemplate(t *corev1.PodTemplateSpec) {
+	for i := range t.Spec.InitContainers {
+		t.Spec.InitContainers[i].Env = env(t.Spec.InitContainers[i].Env)
+	}
+	for i := range t.Spec.Containers {
+		t.Spec.Containers[i].Env = env(t.Spec.Containers[i].Env)
+	}
+}
+
+func secret(s *corev1.Secret) *corev1.Secret {
+	out := s.DeepCopy()
+	for k := range out.Data {
+		out.Data[k] = []byte(Placeholder)
+	}
+	for k := range out.StringData {
+		out.StringData[k] = Placeholder
+	}
+	redactAnnotations(out.Annotations)
+	return out
+}
+
+func wordpress(w *examplev1.Wordpress) *examplev1.Wordpress {
+	out := w.DeepCopy()
+	out.Spec = wordpressSpec(out.Spec)
+	redactAnnotations(out.Annotations)
+	return out
+}
+
+func wordpressSpec(s examplev1.WordpressSpec) examplev1.WordpressSpec {
+	if s.Password != "" {
+		s.Password = Placeholder
+	}
+	return s
+}
+
+func redactAnnotations(annotations map[string]string) {
+	if _, ok := annotations[lastAppliedAnnotation]; ok {
+		annotations[lastAppliedAnnotation] = Placeholder
+	}
+}
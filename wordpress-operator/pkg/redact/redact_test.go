@@ -0,0 +1,105 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	examplev1 "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
+)
+
+// captureLogger prints every message and key/value pair it receives into buf,
+// at every verbosity, so that nothing handed to it escapes the test.
+type captureLogger struct {
+	buf    *bytes.Buffer
+	values []interface{}
+}
+
+func (c captureLogger) Init(logr.RuntimeInfo) {}
+
+func (c captureLogger) Enabled(level int) bool { return true }
+
+func (c captureLogger) Info(level int, msg string, keysAndValues ...interface{}) {
+	fmt.Fprintf(c.buf, "%s %+v %+v\n", msg, c.values, keysAndValues)
+}
+
+func (c captureLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	fmt.Fprintf(c.buf, "%v %s %+v %+v\n", err, msg, c.values, keysAndValues)
+}
+
+func (c captureLogger) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return captureLogger{c.buf, append(append([]interface{}{}, c.values...), keysAndValues...)}
+}
+
+func (c captureLogger) WithName(name string) logr.LogSink { return c }
+
+func TestLoggerRedactsPasswords(t *testing.T) {
+	const password = "DirtyLittleSecret"
+
+	wp := &examplev1.Wordpress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mysite",
+			Namespace: "default",
+			Annotations: map[string]string{
+				lastAppliedAnnotation: `{"spec":{"sqlRootPassword":"` + password + `"}}`,
+			},
+		},
+		Spec: examplev1.WordpressSpec{Password: password},
+	}
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte(password)},
+		StringData: map[string]string{"app-password": password},
+	}
+
+	buf := &bytes.Buffer{}
+	log := NewLogger(logr.New(captureLogger{buf: buf})).WithName("test").WithValues("sqlRootPassword", password)
+
+	log.Info("Reconciling Wordpress", "Wordpress", wp, "Spec", wp.Spec)
+	log.Info("Creating a new Secret", "Secret.Name", sec.Name, "Secret", sec, "Data", sec.Data["password"])
+	log.V(1).Info("Debugging", "Wordpress", *wp, "Secret", *sec, "rootPassword", password)
+	log.Error(errors.New("failed"), "Failed to update Secret", "Secret", sec, "Password", password)
+	log.Info("Updating Secret data", "Data", map[string][]byte{"root-password": []byte(password)}, "Secret.StringData", map[string]string{"wp-config": password})
+	log.Info("Setting environment", "Env", []corev1.EnvVar{{Name: "MYSQL_ROOT_PASSWORD", Value: password}, {Name: "MYSQL_DATABASE", Value: "wordpress"}})
+	log.Info("Labelling", "Labels", map[string]string{"app": "wordpress"})
+	log.Error(fmt.Errorf("running Job: %w", errors.New("ERROR 1396: ALTER USER 'root' IDENTIFIED BY '"+password+"'")), "Failed to change password")
+	log.Error(errors.New(`applying: {"stringData":{"password":"`+password+`"}}`), "Failed to apply Secret")
+
+	out := buf.String()
+	if strings.Contains(out, password) {
+		t.Fatalf("password found in log output:\n%s", out)
+	}
+	if !strings.Contains(out, Placeholder) {
+		t.Errorf("expected %q in log output:\n%s", Placeholder, out)
+	}
+	// Non-sensitive values still make it through.
+	if !strings.Contains(out, "Secret.Name mysite") {
+		t.Errorf("expected the Secret name in log output:\n%s", out)
+	}
+
+	// The keys of data maps are kept.
+	if !strings.Contains(out, "wp-config:"+Placeholder) || !strings.Contains(out, "root-password:") {
+		t.Errorf("expected the keys of the data maps in log output:\n%s", out)
+	}
+
+	// Maps that are not the data of a Secret are left alone, and so are
+	// environment variables that hold no credential.
+	if !strings.Contains(out, "app:wordpress") || !strings.Contains(out, "Value:wordpress") {
+		t.Errorf("expected the labels and the database name in log output:\n%s", out)
+	}
+	// Errors keep what they say, less the credential.
+	if !strings.Contains(out, "ERROR 1396: ALTER USER 'root' IDENTIFIED BY "+Placeholder) {
+		t.Errorf("expected the scrubbed error in log output:\n%s", out)
+	}
+
+	// The logged objects themselves are left untouched.
+	if wp.Spec.Password != password || string(sec.Data["password"]) != password {
+		t.Errorf("redaction modified the logged objects")
+	}
+}
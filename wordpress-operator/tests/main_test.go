@@ -1,11 +1,51 @@
 package e2e
 
 import (
+	"fmt"
+	"os"
 	"testing"
 
-	f "github.com/operator-framework/operator-sdk/pkg/test"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/renan-campos/wordpress-operator/pkg/apis"
+)
+
+// namespaceEnvVar names the namespace the operator under test is deployed to.
+const namespaceEnvVar = "TEST_NAMESPACE"
+
+var (
+	// kubeClient talks to the cluster the operator under test runs in. It is
+	// nil when there is no cluster to test against.
+	kubeClient client.Client
+	namespace  string
 )
 
 func TestMain(m *testing.M) {
-	f.MainEntry(m)
+	namespace = os.Getenv(namespaceEnvVar)
+	if namespace != "" {
+		if err := setup(); err != nil {
+			fmt.Fprintf(os.Stderr, "e2e: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+func setup() error {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return err
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := apis.AddToScheme(scheme); err != nil {
+		return err
+	}
+	kubeClient, err = client.New(cfg, client.Options{Scheme: scheme})
+	return err
 }
@@ -3,30 +3,27 @@ package e2e
 import (
 	goctx "context"
 	"fmt"
+	"path"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/renan-campos/wordpress-operator/pkg/apis"
 	operator "github.com/renan-campos/wordpress-operator/pkg/apis/example/v1"
 
-	framework "github.com/operator-framework/operator-sdk/pkg/test"
-	"github.com/operator-framework/operator-sdk/pkg/test/e2eutil"
+	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 var (
-	retryInterval        = time.Second * 5
-	timeout              = time.Second * 60
-	cleanupRetryInterval = time.Second * 1
-	cleanupTimeout       = time.Second * 5
+	retryInterval = time.Second * 5
+	timeout       = time.Second * 60
 )
 
 func TestWordpress(t *testing.T) {
-	wordpressList := &operator.WordpressList{}
-	err := framework.AddToFrameworkScheme(apis.AddToScheme, wordpressList)
-	if err != nil {
-		t.Fatalf("failed to add custom resource scheme to framework: %v", err)
+	if kubeClient == nil {
+		t.Skipf("%s is not set; deploy the operator to a namespace of a cluster and set it to run the e2e tests", namespaceEnvVar)
 	}
 	// run subtests
 	t.Run("wordpress-group", func(t *testing.T) {
@@ -37,58 +34,65 @@ func TestWordpress(t *testing.T) {
 
 func WordpressCluster(t *testing.T) {
 	t.Parallel()
-	ctx := framework.NewTestCtx(t)
-	defer ctx.Cleanup()
-	err := ctx.InitializeClusterResources(&framework.CleanupOptions{TestContext: ctx, Timeout: cleanupTimeout, RetryInterval: cleanupRetryInterval})
-	if err != nil {
-		t.Fatalf("failed to initialize cluster resources: %v", err)
-	}
-	t.Log("Initialized cluster resources")
-	namespace, err := ctx.GetOperatorNamespace()
-	if err != nil {
-		t.Fatal(err)
-	}
-	// get global framework variables
-	f := framework.Global
-	err = e2eutil.WaitForOperatorDeployment(t, f.KubeClient, namespace, "wordpress-operator", 1, retryInterval, timeout)
+	err := waitForOperatorDeployment(t, "wordpress-operator")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if err = wordpressConfigTest(t, f, ctx); err != nil {
+	if err = wordpressConfigTest(t); err != nil {
 		t.Fatal(err)
 	}
 
 	// TODO: Create more tests once there are utilities for watching for the creation of: {Service, PVC, Secret}
 }
 
+// waitForOperatorDeployment waits until the named Deployment of the operator
+// has an available replica.
+func waitForOperatorDeployment(t *testing.T, name string) error {
+	return wait.PollImmediate(retryInterval, timeout, func() (bool, error) {
+		dep := &appsv1.Deployment{}
+		err := kubeClient.Get(goctx.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, dep)
+		if err != nil {
+			t.Logf("Waiting for Deployment %s: %v", name, err)
+			return false, nil
+		}
+		return dep.Status.AvailableReplicas > 0, nil
+	})
+}
+
+// siteName returns the name of the Wordpress created by the subtest t. The
+// subtests run in parallel in the namespace of the operator, so each needs a
+// site of its own.
+func siteName(t *testing.T) string {
+	return "example-" + strings.ToLower(path.Base(t.Name()))
+}
+
 // Checks that the sqlRootPassword is saved.
 // TODO: When this actually does something, this test should be changed
 //       to ensure the sqlRootPassword is NOT saved.
-func wordpressConfigTest(t *testing.T, f *framework.Framework, ctx *framework.TestCtx) error {
-	namespace, err := ctx.GetOperatorNamespace()
-	if err != nil {
-		return fmt.Errorf("could not get namespace: %v", err)
-	}
-
+func wordpressConfigTest(t *testing.T) error {
 	// create wordpress custom resource
 	exampleWordpress := &operator.Wordpress{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "example-wordpress",
+			Name:      siteName(t),
 			Namespace: namespace,
 		},
 		Spec: operator.WordpressSpec{
 			Password: "DirtyLittleSecret",
 		},
 	}
-	// use TestCtx's create helper to create the object and add a cleanup function for the new object
-	err = f.Client.Create(goctx.TODO(), exampleWordpress, &framework.CleanupOptions{TestContext: ctx, Timeout: cleanupTimeout, RetryInterval: cleanupRetryInterval})
+	err := kubeClient.Create(goctx.TODO(), exampleWordpress)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := kubeClient.Delete(goctx.TODO(), exampleWordpress); err != nil {
+			t.Logf("Could not clean up Wordpress %s: %v", exampleWordpress.Name, err)
+		}
+	}()
 
 	createdWordpress := &operator.Wordpress{}
-	err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: "example-wordpress", Namespace: namespace}, createdWordpress)
+	err = kubeClient.Get(goctx.TODO(), types.NamespacedName{Name: exampleWordpress.Name, Namespace: namespace}, createdWordpress)
 	if err != nil {
 		return err
 	}